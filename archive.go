@@ -0,0 +1,280 @@
+package chunkeduploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafeArchive is returned by ArchiveExtractor for archives it refuses
+// to extract: entries escaping the target directory, links, or limits
+// exceeded.
+var ErrUnsafeArchive = errors.New("unsafe archive")
+
+// ArchiveExtractor is a Processor that extracts ZIP, TAR and gzipped TAR
+// uploads, recognized by a .zip, .tar, .tar.gz or .tgz name and matching
+// content, into a directory next to the stored file, named after it with an
+// "_extracted" suffix. The entries are listed under "archiveManifest" and the
+// directory under "extractedTo". Other files are left alone.
+//
+// Archives with entries that would land outside the directory, symbolic or
+// hard links, or that exceed a limit fail with ErrUnsafeArchive, and nothing
+// is extracted. Limits apply to the bytes actually extracted, not the sizes
+// the archive declares.
+type ArchiveExtractor struct {
+	MaxEntries   int     // entries per archive, default 10000
+	MaxEntrySize int64   // extracted bytes per entry, default 1GB
+	MaxTotalSize int64   // extracted bytes per archive, default 4GB
+	MaxRatio     float64 // extracted bytes per archive byte, default 100
+}
+
+// ArchiveEntry describes an extracted file or directory.
+type ArchiveEntry struct {
+	Name string `json:"name"` // slash-separated path within the archive
+	Size int64  `json:"size"`
+	Dir  bool   `json:"dir,omitempty"`
+}
+
+// Process implements Processor.
+func (a *ArchiveExtractor) Process(ctx context.Context, file *ProcessedFile) error {
+	name, _ := file.Metadata["originalName"].(string)
+	if name == "" {
+		name = filepath.Base(file.Path)
+	}
+	format := archiveFormat(name)
+	if format == "" {
+		return nil
+	}
+
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error opening archive: %v", err)
+	}
+
+	// The content must be what the name declares; anything else is left alone
+	r := bufio.NewReader(f)
+	switch format {
+	case "zip":
+		magic, _ := r.Peek(4)
+		if !bytes.Equal(magic, []byte("PK\x03\x04")) && !bytes.Equal(magic, []byte("PK\x05\x06")) {
+			return nil
+		}
+	case "tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil
+		}
+		defer gz.Close()
+		if r = bufio.NewReader(gz); !isTar(r) {
+			return nil
+		}
+	case "tar":
+		if !isTar(r) {
+			return nil
+		}
+	}
+
+	x := &extraction{
+		ArchiveExtractor: a.withDefaults(),
+		ctx:              ctx,
+		dir:              strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + "_extracted",
+		archiveSize:      max(info.Size(), 1),
+	}
+	// A directory that already exists belongs to someone else and is never
+	// written to or removed
+	if err := os.Mkdir(x.dir, 0755); err != nil {
+		return fmt.Errorf("error creating extraction directory: %v", err)
+	}
+	if format == "zip" {
+		err = x.extractZip(f, info.Size())
+	} else {
+		err = x.extractTar(r)
+	}
+	if err != nil {
+		os.RemoveAll(x.dir)
+		return fmt.Errorf("error extracting archive: %w", err)
+	}
+
+	file.AddDerived("extracted", x.dir)
+	file.Metadata["extractedTo"] = x.dir
+	file.Metadata["archiveManifest"] = x.entries
+	return nil
+}
+
+// archiveFormat returns the archive format a file name declares: "zip",
+// "tar" or "tar.gz", or "" for other files. Formats built on ZIP, such as
+// .docx, .jar or .epub, are documents rather than archives to extract.
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// isTar reports whether r starts with a POSIX tar header.
+func isTar(r *bufio.Reader) bool {
+	header, _ := r.Peek(512)
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+func (a *ArchiveExtractor) withDefaults() ArchiveExtractor {
+	limits := *a
+	if limits.MaxEntries <= 0 {
+		limits.MaxEntries = 10000
+	}
+	if limits.MaxEntrySize <= 0 {
+		limits.MaxEntrySize = 1 << 30
+	}
+	if limits.MaxTotalSize <= 0 {
+		limits.MaxTotalSize = 4 << 30
+	}
+	if limits.MaxRatio <= 0 {
+		limits.MaxRatio = 100
+	}
+	return limits
+}
+
+// extraction tracks one archive being extracted.
+type extraction struct {
+	ArchiveExtractor
+	ctx         context.Context
+	dir         string
+	archiveSize int64
+	entries     []ArchiveEntry
+	total       int64
+}
+
+func (x *extraction) extractZip(f *os.File, size int64) error {
+	archive, err := zip.NewReader(f, size)
+	if err != nil {
+		return fmt.Errorf("error reading archive: %v", err)
+	}
+	for _, entry := range archive.File {
+		mode := entry.Mode()
+		if mode&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a link", ErrUnsafeArchive, entry.Name)
+		}
+		if mode.IsDir() {
+			if err := x.add(entry.Name, true, nil, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("%w: %s is not a regular file", ErrUnsafeArchive, entry.Name)
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", entry.Name, err)
+		}
+		err = x.add(entry.Name, false, r, max(int64(entry.CompressedSize64), 1))
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extraction) extractTar(r io.Reader) error {
+	archive := tar.NewReader(r)
+	for {
+		entry, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading archive: %v", err)
+		}
+		switch entry.Typeflag {
+		case tar.TypeDir:
+			err = x.add(entry.Name, true, nil, 0)
+		case tar.TypeReg:
+			err = x.add(entry.Name, false, archive, 0)
+		case tar.TypeSymlink, tar.TypeLink:
+			err = fmt.Errorf("%w: %s is a link", ErrUnsafeArchive, entry.Name)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = fmt.Errorf("%w: %s is not a regular file", ErrUnsafeArchive, entry.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// add extracts one entry. compressed is the entry's compressed size, for
+// formats that compress entries separately, or 0.
+func (x *extraction) add(name string, dir bool, r io.Reader, compressed int64) error {
+	if err := x.ctx.Err(); err != nil {
+		return err
+	}
+	if len(x.entries) >= x.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrUnsafeArchive, x.MaxEntries)
+	}
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if strings.Contains(name, "\\") || !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return fmt.Errorf("%w: %s escapes the extraction directory", ErrUnsafeArchive, name)
+	}
+	target := filepath.Join(x.dir, filepath.FromSlash(clean))
+
+	if dir {
+		if clean == "." {
+			return nil
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("error creating %s: %v", name, err)
+		}
+		x.entries = append(x.entries, ArchiveEntry{Name: clean, Dir: true})
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", name, err)
+	}
+	// O_EXCL rejects duplicate entries rather than letting one overwrite another
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("%w: cannot create %s: %v", ErrUnsafeArchive, name, err)
+	}
+	limit := min(x.MaxEntrySize, x.MaxTotalSize-x.total, int64(x.MaxRatio*float64(x.archiveSize))-x.total)
+	if compressed > 0 {
+		limit = min(limit, int64(x.MaxRatio*float64(compressed)))
+	}
+	written, err := io.Copy(out, io.LimitReader(r, max(limit, 0)+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %v", name, err)
+	}
+	if written > limit {
+		return fmt.Errorf("%w: %s exceeds the size or compression ratio limit", ErrUnsafeArchive, name)
+	}
+
+	x.total += written
+	x.entries = append(x.entries, ArchiveEntry{Name: clean, Size: written})
+	return nil
+}
@@ -0,0 +1,178 @@
+package chunkeduploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	content string
+	link    bool
+}
+
+func buildZip(t *testing.T, entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.link {
+			header.SetMode(os.ModeSymlink | 0777)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		f.Write([]byte(entry.content))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if entry.link {
+			header.Typeflag, header.Size, header.Linkname = tar.TypeSymlink, 0, entry.content
+		} else if strings.HasSuffix(entry.name, "/") {
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if !entry.link {
+			w.Write([]byte(entry.content))
+		}
+	}
+	w.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func extractArchive(t *testing.T, extractor *ArchiveExtractor, name string, archive []byte) (*ProcessedFile, error) {
+	path := filepath.Join(t.TempDir(), name)
+	os.WriteFile(path, archive, 0644)
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	return file, extractor.Process(context.Background(), file)
+}
+
+func TestArchiveExtractor_Extract(t *testing.T) {
+	u, _, _ := processingUploader(t, ProcessingStep{Name: "extract", Processor: &ArchiveExtractor{}, Required: true})
+	archive := buildZip(t, testEntry{name: "docs/"}, testEntry{name: "docs/readme.txt", content: "hello"}, testEntry{name: "main.go", content: "package main"})
+	result, err := uploadSingleChunk(u, "bundle.zip", string(archive))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	metadata := result["metadata"].(map[string]interface{})
+	manifest := metadata["archiveManifest"].([]ArchiveEntry)
+	if len(manifest) != 3 || manifest[0] != (ArchiveEntry{Name: "docs", Dir: true}) || manifest[1] != (ArchiveEntry{Name: "docs/readme.txt", Size: 5}) {
+		t.Errorf("Expected the manifest in the completion metadata, got %+v", manifest)
+	}
+	dir := metadata["extractedTo"].(string)
+	if content, _ := os.ReadFile(filepath.Join(dir, "docs", "readme.txt")); string(content) != "hello" {
+		t.Errorf("Expected the entry to be extracted, got %q", content)
+	}
+
+	file, err := extractArchive(t, &ArchiveExtractor{}, "bundle.tar.gz", buildTarGz(t, testEntry{name: "a/"}, testEntry{name: "a/b.txt", content: "tar"}))
+	if err != nil {
+		t.Fatalf("Extracting tar.gz failed: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(file.Metadata["extractedTo"].(string), "a", "b.txt")); string(content) != "tar" {
+		t.Errorf("Expected the tar entry to be extracted, got %q", content)
+	}
+
+	file, err = extractArchive(t, &ArchiveExtractor{}, "notes.txt", []byte("not an archive"))
+	if err != nil || len(file.Metadata) != 0 {
+		t.Errorf("Expected other files to be left alone, got %v, %v", file.Metadata, err)
+	}
+}
+
+func TestArchiveExtractor_Guards(t *testing.T) {
+	random := make([]byte, 2048)
+	rand.Read(random)
+	unsafe := map[string][]byte{
+		"zip slip":       buildZip(t, testEntry{name: "../../evil.sh", content: "boom"}),
+		"absolute path":  buildTarGz(t, testEntry{name: "/etc/cron.d/evil", content: "boom"}),
+		"zip symlink":    buildZip(t, testEntry{name: "link", content: "/etc/passwd", link: true}),
+		"tar symlink":    buildTarGz(t, testEntry{name: "link", content: "/etc/passwd", link: true}),
+		"duplicate":      buildZip(t, testEntry{name: "a.txt", content: "1"}, testEntry{name: "a.txt", content: "2"}),
+		"too many":       buildZip(t, testEntry{name: "1"}, testEntry{name: "2"}, testEntry{name: "3"}, testEntry{name: "4"}),
+		"oversized":      buildZip(t, testEntry{name: "big.bin", content: string(random)}),
+		"zip ratio bomb": buildZip(t, testEntry{name: "bomb.bin", content: strings.Repeat("\x00", 1000)}),
+		"tar ratio bomb": buildTarGz(t, testEntry{name: "bomb.bin", content: strings.Repeat("\x00", 1000)}),
+	}
+	extractor := &ArchiveExtractor{MaxEntries: 3, MaxEntrySize: 1500, MaxRatio: 10}
+	for name, archive := range unsafe {
+		fileName := "bundle.zip"
+		if archive[0] == 0x1f {
+			fileName = "bundle.tar.gz"
+		}
+		file, err := extractArchive(t, extractor, fileName, archive)
+		if !errors.Is(err, ErrUnsafeArchive) {
+			t.Errorf("%s: expected ErrUnsafeArchive, got %v", name, err)
+		}
+		dir := strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + "_extracted"
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s: expected partial extraction to be removed", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), "evil.sh")); err == nil {
+		t.Errorf("Zip slip entry was written outside the extraction directory")
+	}
+}
+
+func TestArchiveExtractor_DeclaredTypes(t *testing.T) {
+	zipped := buildZip(t, testEntry{name: "word/document.xml", content: "<w:document/>"})
+	for _, name := range []string{"report.docx", "app.jar", "book.epub", "bundle.bin"} {
+		file, err := extractArchive(t, &ArchiveExtractor{}, name, zipped)
+		if err != nil || len(file.Metadata) != 0 {
+			t.Errorf("%s: expected ZIP-based documents to be left alone, got %v, %v", name, file.Metadata, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("just some compressed text"))
+	gz.Close()
+	for _, name := range []string{"notes.txt.gz", "notes.tar.gz"} {
+		file, err := extractArchive(t, &ArchiveExtractor{}, name, buf.Bytes())
+		if err != nil || len(file.Metadata) != 0 {
+			t.Errorf("%s: expected gzip without a tar inside to be left alone, got %v, %v", name, file.Metadata, err)
+		}
+	}
+
+	file, err := extractArchive(t, &ArchiveExtractor{}, "fake.zip", []byte("not a zip"))
+	if err != nil || len(file.Metadata) != 0 {
+		t.Errorf("Expected content that is not the declared archive to be left alone, got %v, %v", file.Metadata, err)
+	}
+}
+
+func TestArchiveExtractor_ExistingDirectory(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "bundle.zip")
+	os.WriteFile(path, buildZip(t, testEntry{name: "a.txt", content: "new"}), 0644)
+	existing := filepath.Join(base, "bundle_extracted")
+	os.Mkdir(existing, 0755)
+	os.WriteFile(filepath.Join(existing, "a.txt"), []byte("old"), 0644)
+
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	if err := (&ArchiveExtractor{}).Process(context.Background(), file); err == nil {
+		t.Error("Expected extraction into an existing directory to fail")
+	}
+	if content, _ := os.ReadFile(filepath.Join(existing, "a.txt")); string(content) != "old" {
+		t.Errorf("Expected the existing directory to be left intact, got %q", content)
+	}
+}
@@ -0,0 +1,122 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ClamdScanner scans files by streaming them to a ClamAV clamd daemon with
+// the INSTREAM command. Zero values select the defaults.
+type ClamdScanner struct {
+	Network   string        // "tcp" or "unix", default "tcp"
+	Address   string        // daemon address or socket path, default "localhost:3310"
+	Timeout   time.Duration // limit for one scan, default one minute
+	ChunkSize int           // bytes sent per INSTREAM chunk, default 64KB
+}
+
+// Scan streams the file at path to clamd and interprets its reply.
+func (c *ClamdScanner) Scan(ctx context.Context, path string) (ScanResult, error) {
+	network, address, timeout, chunkSize := c.Network, c.Address, c.Timeout, c.ChunkSize
+	if network == "" {
+		network = "tcp"
+	}
+	if address == "" {
+		address = "localhost:3310"
+	}
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	if chunkSize <= 0 {
+		chunkSize = 64 << 10
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error opening file for scanning: %v", err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error connecting to clamd: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	reply, err := clamdInstream(conn, f, chunkSize)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error scanning with clamd: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// clamdInstream sends the INSTREAM command with the content of r and returns
+// the daemon's reply. The stream is a series of chunks, each prefixed with
+// its length as a 4-byte big-endian integer, ended by a zero length.
+func clamdInstream(conn net.Conn, r io.Reader, chunkSize int) (string, error) {
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, chunkSize)
+	var length [4]byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(length[:], uint32(n))
+			w.Write(length[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	binary.BigEndian.PutUint32(length[:], 0)
+	w.Write(length[:])
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamdReply interprets replies such as "stream: OK",
+// "stream: Eicar-Signature FOUND" and "INSTREAM size limit exceeded. ERROR".
+func parseClamdReply(reply string) (ScanResult, error) {
+	status := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case status == "OK":
+		return ScanResult{Verdict: VerdictClean}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return ScanResult{Verdict: VerdictInfected, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	}
+	return ScanResult{Verdict: VerdictError}, fmt.Errorf("clamd: %s", reply)
+}
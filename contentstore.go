@@ -0,0 +1,410 @@
+package chunkeduploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRecordNotFound is returned by ContentStore for stored names it has no
+// record of.
+var ErrRecordNotFound = errors.New("record not found")
+
+// ErrInvalidDigest is returned by ContentStore for digests that are not 64
+// lowercase hex characters.
+var ErrInvalidDigest = errors.New("invalid digest")
+
+// ContentStore keeps assembled files under their SHA-256 digest, so that
+// identical content is stored once. Each upload gets a record mapping its
+// stored name to the digest, and every blob counts the records referring to
+// it; deleting the last one removes the blob.
+//
+// The store must be on the same file system as Config.UploadDir, and must
+// not be shared between processes.
+type ContentStore struct {
+	dir   string
+	mutex sync.Mutex // serializes reference counting
+}
+
+// ContentRecord is the lightweight record of one upload in a ContentStore.
+type ContentRecord struct {
+	StoredName   string    `json:"storedName"`
+	Digest       string    `json:"digest"` // hex-encoded SHA-256 of the content
+	OriginalName string    `json:"originalName"`
+	Size         int64     `json:"size"`
+	Created      time.Time `json:"created"`
+}
+
+// NewContentStore creates a store keeping blobs and records under dir.
+func NewContentStore(dir string) *ContentStore {
+	return &ContentStore{dir: dir}
+}
+
+// BlobPath returns where the content with the given digest is stored.
+func (s *ContentStore) BlobPath(digest string) (string, error) {
+	if !validDigest(digest) {
+		return "", ErrInvalidDigest
+	}
+	return s.blobPath(digest), nil
+}
+
+// blobPath is BlobPath for digests known to be valid.
+func (s *ContentStore) blobPath(digest string) string {
+	return filepath.Join(s.dir, "blobs", digest[:2], digest)
+}
+
+func (s *ContentStore) recordPath(storedName string) string {
+	return filepath.Join(s.dir, "records", storedName+".json")
+}
+
+func (s *ContentStore) refsPath(digest string) string {
+	return s.blobPath(digest) + ".refs"
+}
+
+// Store moves the file at path into the store and records it under
+// storedName. If the same content is already stored, the file is deleted
+// and deduplicated is true.
+func (s *ContentStore) Store(path, storedName, originalName string) (record ContentRecord, deduplicated bool, err error) {
+	if !validStoredName(storedName) {
+		return ContentRecord{}, false, fmt.Errorf("invalid stored name %q", storedName)
+	}
+	digest, size, err := fileDigest(path)
+	if err != nil {
+		return ContentRecord{}, false, err
+	}
+	record = ContentRecord{StoredName: storedName, Digest: digest, OriginalName: originalName, Size: size, Created: time.Now()}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(s.recordPath(storedName)); err == nil {
+		return ContentRecord{}, false, fmt.Errorf("record %s already exists", storedName)
+	}
+	if deduplicated, err = s.add(path, record); err != nil {
+		return ContentRecord{}, false, err
+	}
+	return record, deduplicated, nil
+}
+
+// Replace points the record stored under storedName at the content of the
+// file at path, which is moved into the store like in Store. The previous
+// content loses a reference, and is deleted if it was the last one. If the
+// content did not change, the file is just deleted.
+func (s *ContentStore) Replace(path, storedName string) (record ContentRecord, deduplicated bool, err error) {
+	digest, size, err := fileDigest(path)
+	if err != nil {
+		return ContentRecord{}, false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The record is read under the lock, so a concurrent Delete either
+	// happened already or waits until the new content is recorded
+	previous, err := s.Record(storedName)
+	if err != nil {
+		return ContentRecord{}, false, err
+	}
+	record = previous
+	record.Digest, record.Size = digest, size
+	if digest == previous.Digest {
+		os.Remove(path)
+		return record, true, nil
+	}
+	if deduplicated, err = s.add(path, record); err != nil {
+		return ContentRecord{}, false, err
+	}
+	return record, deduplicated, s.release(previous.Digest)
+}
+
+// add moves the file at path into the blob for record's digest, unless that
+// blob exists already, counts the reference and writes the record. The
+// mutex must be held.
+func (s *ContentStore) add(path string, record ContentRecord) (deduplicated bool, err error) {
+	digest := record.Digest
+	refs, err := s.refs(digest)
+	if err != nil {
+		return false, err
+	}
+	blob := s.blobPath(digest)
+	if refs > 0 {
+		deduplicated = true
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return false, fmt.Errorf("error creating blob directory: %v", err)
+		}
+		if err := os.Rename(path, blob); err != nil {
+			return false, fmt.Errorf("error storing blob: %v", err)
+		}
+	}
+	if err := s.setRefs(digest, refs+1); err != nil {
+		if !deduplicated {
+			os.Rename(blob, path)
+		}
+		return false, err
+	}
+	if err := s.writeRecord(record); err != nil {
+		s.setRefs(digest, refs)
+		if !deduplicated {
+			os.Rename(blob, path)
+		}
+		return false, err
+	}
+	if deduplicated {
+		os.Remove(path)
+	}
+	return deduplicated, nil
+}
+
+// Record returns the record stored under storedName.
+func (s *ContentStore) Record(storedName string) (ContentRecord, error) {
+	if !validStoredName(storedName) {
+		return ContentRecord{}, ErrRecordNotFound
+	}
+	data, err := os.ReadFile(s.recordPath(storedName))
+	if errors.Is(err, os.ErrNotExist) {
+		return ContentRecord{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return ContentRecord{}, fmt.Errorf("error reading record: %v", err)
+	}
+	var record ContentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ContentRecord{}, fmt.Errorf("error decoding record: %v", err)
+	}
+	if !validDigest(record.Digest) {
+		return ContentRecord{}, fmt.Errorf("error decoding record: %w", ErrInvalidDigest)
+	}
+	return record, nil
+}
+
+// Open opens the content of the upload stored under storedName.
+func (s *ContentStore) Open(storedName string) (*os.File, error) {
+	record, err := s.Record(storedName)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(s.blobPath(record.Digest))
+}
+
+// RefCount returns how many records refer to the content with the given digest.
+func (s *ContentStore) RefCount(digest string) (int, error) {
+	if !validDigest(digest) {
+		return 0, ErrInvalidDigest
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.refs(digest)
+}
+
+// Delete removes the record stored under storedName, and its blob if no
+// other record refers to it.
+func (s *ContentStore) Delete(storedName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, err := s.Record(storedName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.recordPath(storedName)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("error deleting record: %v", err)
+	}
+	return s.release(record.Digest)
+}
+
+// release drops a reference to a blob, deleting the blob with its last
+// reference. The mutex must be held.
+func (s *ContentStore) release(digest string) error {
+	refs, err := s.refs(digest)
+	if err != nil {
+		return err
+	}
+	if refs > 1 {
+		return s.setRefs(digest, refs-1)
+	}
+	if err := os.Remove(s.blobPath(digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting blob: %v", err)
+	}
+	os.Remove(s.refsPath(digest))
+	return nil
+}
+
+func (s *ContentStore) refs(digest string) (int, error) {
+	data, err := os.ReadFile(s.refsPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading reference count: %v", err)
+	}
+	refs, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("error reading reference count: %v", err)
+	}
+	return refs, nil
+}
+
+func (s *ContentStore) setRefs(digest string, refs int) error {
+	if err := writeFileAtomic(s.refsPath(digest), []byte(strconv.Itoa(refs))); err != nil {
+		return fmt.Errorf("error writing reference count: %v", err)
+	}
+	return nil
+}
+
+func (s *ContentStore) writeRecord(record ContentRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding record: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, "records"), 0755); err != nil {
+		return fmt.Errorf("error creating record directory: %v", err)
+	}
+	if err := writeFileAtomic(s.recordPath(record.StoredName), data); err != nil {
+		return fmt.Errorf("error writing record: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data.
+func writeFileAtomic(path string, data []byte) error {
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// validStoredName rejects names that would place a record outside the
+// records directory.
+func validStoredName(name string) bool {
+	return name != "" && filepath.Base(name) == name && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// validDigest reports whether digest is a hex-encoded SHA-256 as produced
+// by fileDigest.
+func validDigest(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range digest {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// fileDigest returns the hex SHA-256 and size of the file at path.
+func fileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("error hashing file: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// storeContent moves an assembled file into Config.ContentStore, if set, and
+// points its metadata at the blob.
+func (u *Uploader) storeContent(metadata map[string]interface{}) error {
+	store := u.config.ContentStore
+	if store == nil {
+		return nil
+	}
+	record, deduplicated, err := store.Store(metadata["path"].(string), metadata["storedName"].(string), metadata["originalName"].(string))
+	if err != nil {
+		return fmt.Errorf("error storing content: %w", err)
+	}
+	metadata["digest"] = record.Digest
+	metadata["path"] = store.blobPath(record.Digest)
+	metadata["deduplicated"] = deduplicated
+	return nil
+}
+
+// discardStored deletes an assembled file that will not be published. Files
+// in the content store only lose their reference, as the blob may be shared.
+func (u *Uploader) discardStored(metadata map[string]interface{}) {
+	if _, stored := metadata["digest"]; stored && u.config.ContentStore != nil {
+		u.config.ContentStore.Delete(metadata["storedName"].(string))
+		return
+	}
+	os.Remove(metadata["path"].(string))
+}
+
+// checkoutContent gives background processing steps a private copy of a file
+// in Config.ContentStore, at the upload's own path, so they never work on a
+// blob other uploads share. It returns "" for files that are not stored.
+func (u *Uploader) checkoutContent(info SessionInfo, metadata map[string]interface{}) (string, error) {
+	store := u.config.ContentStore
+	digest, stored := metadata["digest"].(string)
+	if store == nil || !stored {
+		return "", nil
+	}
+	work := filepath.Join(u.config.UploadDir, filepath.FromSlash(info.Prefix), metadata["storedName"].(string))
+	if err := copyFile(store.blobPath(digest), work); err != nil {
+		return "", fmt.Errorf("error copying stored content for processing: %v", err)
+	}
+	metadata["path"] = work
+	return work, nil
+}
+
+// checkinContent stores the private copy made by checkoutContent once the
+// background steps are done. Changed content is stored under its new digest.
+func (u *Uploader) checkinContent(metadata map[string]interface{}, work string) error {
+	store := u.config.ContentStore
+	record, deduplicated, err := store.Replace(work, metadata["storedName"].(string))
+	if err != nil {
+		os.Remove(work)
+		return fmt.Errorf("error storing processed content: %w", err)
+	}
+	if record.Digest != metadata["digest"] {
+		metadata["digest"] = record.Digest
+		metadata["fileSize"] = record.Size
+		metadata["deduplicated"] = deduplicated
+	}
+	metadata["path"] = store.blobPath(record.Digest)
+	return nil
+}
+
+// copyFile copies the file at src to a new file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
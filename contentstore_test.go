@@ -0,0 +1,254 @@
+package chunkeduploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploader_ContentAddressedStorage(t *testing.T) {
+	dir := t.TempDir()
+	store := NewContentStore(filepath.Join(dir, "store"))
+	u := NewUploader(Config{
+		TempDir:      filepath.Join(dir, "chunks"),
+		UploadDir:    filepath.Join(dir, "uploads"),
+		ContentStore: store,
+	})
+
+	first, err := uploadSingleChunk(u, "setup.exe", "installer bytes")
+	if err != nil {
+		t.Fatalf("First upload failed: %v", err)
+	}
+	second, err := uploadSingleChunk(u, "setup-copy.exe", "installer bytes")
+	if err != nil {
+		t.Fatalf("Second upload failed: %v", err)
+	}
+	firstMeta := first["metadata"].(map[string]interface{})
+	secondMeta := second["metadata"].(map[string]interface{})
+
+	sum := sha256.Sum256([]byte("installer bytes"))
+	digest := hex.EncodeToString(sum[:])
+	if firstMeta["digest"] != digest || secondMeta["digest"] != digest {
+		t.Fatalf("Expected both uploads to have digest %s, got %v and %v", digest, firstMeta["digest"], secondMeta["digest"])
+	}
+	if firstMeta["deduplicated"] != false || secondMeta["deduplicated"] != true {
+		t.Errorf("Expected only the second upload to be deduplicated, got %v and %v", firstMeta["deduplicated"], secondMeta["deduplicated"])
+	}
+	blob, _ := store.BlobPath(digest)
+	if firstMeta["path"] != blob || secondMeta["path"] != blob {
+		t.Errorf("Expected both uploads to point at the blob, got %v and %v", firstMeta["path"], secondMeta["path"])
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 0 {
+		t.Errorf("Expected no files left in the upload directory, found %d", len(entries))
+	}
+	if refs, _ := store.RefCount(digest); refs != 2 {
+		t.Errorf("Expected 2 references, got %d", refs)
+	}
+
+	record, err := store.Record(secondMeta["storedName"].(string))
+	if err != nil || record.Digest != digest || record.OriginalName != "setup-copy.exe" || record.Size != 15 {
+		t.Errorf("Expected a record mapping the stored name to the digest, got %+v, %v", record, err)
+	}
+	f, err := store.Open(secondMeta["storedName"].(string))
+	if err != nil {
+		t.Fatalf("Failed to open stored content: %v", err)
+	}
+	content, _ := io.ReadAll(f)
+	f.Close()
+	if string(content) != "installer bytes" {
+		t.Errorf("Expected stored content, got %q", content)
+	}
+
+	if err := store.Delete(firstMeta["storedName"].(string)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("Blob should remain while referenced: %v", err)
+	}
+	if err := store.Delete(secondMeta["storedName"].(string)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("Expected the blob to be removed with its last reference, got %v", err)
+	}
+	if err := store.Delete(secondMeta["storedName"].(string)); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound for a deleted record, got %v", err)
+	}
+}
+
+func TestUploader_ContentStoreVeto(t *testing.T) {
+	dir := t.TempDir()
+	store := NewContentStore(filepath.Join(dir, "store"))
+	veto := false
+	u := NewUploader(Config{
+		TempDir:      filepath.Join(dir, "chunks"),
+		UploadDir:    filepath.Join(dir, "uploads"),
+		ContentStore: store,
+		Hooks: Hooks{OnComplete: func(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error {
+			if veto {
+				return errors.New("rejected")
+			}
+			return nil
+		}},
+	})
+
+	kept, err := uploadSingleChunk(u, "a.bin", "shared")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	veto = true
+	if _, err := uploadSingleChunk(u, "b.bin", "shared"); err == nil {
+		t.Fatalf("Expected the vetoed upload to fail")
+	}
+
+	digest := kept["metadata"].(map[string]interface{})["digest"].(string)
+	if refs, _ := store.RefCount(digest); refs != 1 {
+		t.Errorf("Expected the vetoed upload to drop its reference, got %d references", refs)
+	}
+	blob, _ := store.BlobPath(digest)
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("The shared blob should survive a vetoed duplicate: %v", err)
+	}
+	if _, err := store.Record("../escape"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Expected invalid stored names to be rejected, got %v", err)
+	}
+	for _, invalid := range []string{"", "a", "../../etc/passwd", strings.ToUpper(digest)} {
+		if _, err := store.BlobPath(invalid); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Expected ErrInvalidDigest for %q, got %v", invalid, err)
+		}
+		if _, err := store.RefCount(invalid); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Expected ErrInvalidDigest from RefCount for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestUploader_ContentStoreBackgroundProcessing(t *testing.T) {
+	dir := t.TempDir()
+	store := NewContentStore(filepath.Join(dir, "store"))
+	events := NewChannelSink(16)
+	u := NewUploader(Config{
+		TempDir:      filepath.Join(dir, "chunks"),
+		UploadDir:    filepath.Join(dir, "uploads"),
+		ContentStore: store,
+		EventSink:    events,
+		Processors: []ProcessingStep{
+			{Name: "strip", Processor: &MetadataStripper{}, Background: true},
+			{Name: "extract", Processor: &ArchiveExtractor{}, Background: true},
+		},
+	})
+
+	photo := string(jpegWithMetadata(t))
+	archive := string(buildZip(t, testEntry{name: "readme.txt", content: "hello"}))
+	var uploaded []map[string]interface{}
+	for _, upload := range []struct{ name, data string }{{"a.jpg", photo}, {"b.jpg", photo}, {"a.zip", archive}, {"b.zip", archive}} {
+		result, err := uploadSingleChunk(u, upload.name, upload.data)
+		if err != nil {
+			t.Fatalf("Upload of %s failed: %v", upload.name, err)
+		}
+		uploaded = append(uploaded, result["metadata"].(map[string]interface{}))
+	}
+	if err := u.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	processed := make(map[string]Event)
+	for len(events.C) > 0 {
+		if event := <-events.C; event.Type == EventProcessed {
+			processed[event.FileName] = event
+		}
+	}
+	if len(processed) != 4 {
+		t.Fatalf("Expected a processed event per upload, got %d", len(processed))
+	}
+	for name, event := range processed {
+		if event.Error != "" {
+			t.Errorf("Background processing of %s failed: %s", name, event.Error)
+		}
+	}
+
+	// The original photo blob is never rewritten; both uploads move to the
+	// stripped content, and the unreferenced original is deleted
+	original := uploaded[0]["digest"].(string)
+	stripped := processed["a.jpg"].Metadata["digest"].(string)
+	if stripped == original || processed["b.jpg"].Metadata["digest"] != stripped {
+		t.Fatalf("Expected both photos to move to the stripped digest, got %s and %v", stripped, processed["b.jpg"].Metadata["digest"])
+	}
+	blob, _ := store.BlobPath(stripped)
+	content, _ := os.ReadFile(blob)
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != stripped || strings.Contains(string(content), "GPSLatitude") {
+		t.Errorf("Expected the stripped blob to match its digest")
+	}
+	if refs, _ := store.RefCount(stripped); refs != 2 {
+		t.Errorf("Expected 2 references to the stripped content, got %d", refs)
+	}
+	if refs, _ := store.RefCount(original); refs != 0 {
+		t.Errorf("Expected the original content to be released, got %d references", refs)
+	}
+
+	// Each archive upload is extracted into its own directory, outside the store
+	first, second := processed["a.zip"].Metadata["extractedTo"].(string), processed["b.zip"].Metadata["extractedTo"].(string)
+	if first == second || strings.HasPrefix(first, filepath.Join(dir, "store")) {
+		t.Errorf("Expected separate extraction directories outside the store, got %s and %s", first, second)
+	}
+	for _, extracted := range []string{first, second} {
+		if content, _ := os.ReadFile(filepath.Join(extracted, "readme.txt")); string(content) != "hello" {
+			t.Errorf("Expected %s to keep its extracted files, got %q", extracted, content)
+		}
+	}
+	if refs, _ := store.RefCount(uploaded[2]["digest"].(string)); refs != 2 {
+		t.Errorf("Unchanged archives should keep their shared blob, got %d references", refs)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 2 {
+		t.Errorf("Expected only the extraction directories next to the store, found %d entries", len(entries))
+	}
+}
+
+func TestContentStore_ReplaceDuringDelete(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		dir := t.TempDir()
+		store := NewContentStore(filepath.Join(dir, "store"))
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0644)
+			return path
+		}
+		x, _, err := store.Store(write("x", "shared"), "x.txt", "x.txt")
+		if err != nil {
+			t.Fatalf("Failed to store x: %v", err)
+		}
+		if _, _, err := store.Store(write("y", "shared"), "y.txt", "y.txt"); err != nil {
+			t.Fatalf("Failed to store y: %v", err)
+		}
+
+		work := write("work", "processed")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if _, _, err := store.Replace(work, "x.txt"); err != nil && !errors.Is(err, ErrRecordNotFound) {
+				t.Errorf("Unexpected replace error: %v", err)
+			}
+		}()
+		if err := store.Delete("x.txt"); err != nil {
+			t.Errorf("Unexpected delete error: %v", err)
+		}
+		<-done
+
+		if _, err := store.Record("x.txt"); !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("Expected the deleted record to stay deleted, got %v", err)
+		}
+		f, err := store.Open("y.txt")
+		if err != nil {
+			t.Fatalf("Expected the shared blob to survive, got %v", err)
+		}
+		f.Close()
+		if refs, _ := store.RefCount(x.Digest); refs != 1 {
+			t.Fatalf("Expected one reference to the shared blob, got %d", refs)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package chunkeduploader
+
+// freeSpace is not implemented on this platform; free space is not checked.
+func freeSpace(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,190 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType identifies what happened to an upload.
+type EventType string
+
+const (
+	EventCompleted EventType = "upload.completed"
+	EventFailed    EventType = "upload.failed"
+	EventAborted   EventType = "upload.aborted"
+	EventExpired   EventType = "upload.expired"
+
+	// EventProcessed follows upload.completed once the background
+	// processing steps have run.
+	EventProcessed EventType = "upload.processed"
+)
+
+// FileMetadata describes an assembled file, as built by stitchFile.
+type FileMetadata struct {
+	OriginalName string `json:"originalName"`
+	StoredName   string `json:"storedName"`
+	FileSize     int64  `json:"fileSize"`
+	MimeType     string `json:"mimeType"`
+	Path         string `json:"path"`
+	Owner        string `json:"owner,omitempty"`
+
+	// MimeType is implied by the file name; DetectedMimeType comes from the
+	// file's content.
+	DetectedMimeType string `json:"detectedMimeType,omitempty"`
+
+	ScanVerdict string `json:"scanVerdict,omitempty"` // set when a Scanner is configured
+	Digest      string `json:"digest,omitempty"`      // set when a ContentStore is configured
+}
+
+// Event describes a change in the lifecycle of an upload session.
+type Event struct {
+	ID               string                 `json:"id"`
+	Type             EventType              `json:"type"`
+	UploadID         string                 `json:"uploadId,omitempty"`
+	FileName         string                 `json:"fileName"`
+	Time             time.Time              `json:"time"`
+	File             *FileMetadata          `json:"file,omitempty"`     // set for completed uploads
+	Metadata         map[string]interface{} `json:"metadata,omitempty"` // full metadata, including fields added by hooks
+	AdditionalParams map[string]interface{} `json:"additionalParams,omitempty"`
+	Error            string                 `json:"error,omitempty"`
+}
+
+// EventSink receives upload events, for example to publish them onto a
+// message bus. Publish may be called concurrently.
+type EventSink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// FanOut is an EventSink that publishes every event to each of its sinks.
+type FanOut struct {
+	sinks []EventSink
+}
+
+// NewFanOut creates a FanOut publishing to sinks in order.
+func NewFanOut(sinks ...EventSink) *FanOut {
+	return &FanOut{sinks: sinks}
+}
+
+// Publish delivers event to every sink, even if some fail, and returns the
+// combined errors.
+func (f *FanOut) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, sink := range f.sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// JSONLinesSink appends each event as a line of JSON to a file.
+type JSONLinesSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewJSONLinesSink opens path for appending, creating it if needed.
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening event file: %v", err)
+	}
+	return &JSONLinesSink{file: file}, nil
+}
+
+// Publish writes event as a single JSON line.
+func (s *JSONLinesSink) Publish(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing event: %v", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (s *JSONLinesSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// ChannelSink sends events to a Go channel, for in-process consumers.
+type ChannelSink struct {
+	C chan Event
+}
+
+// NewChannelSink creates a ChannelSink whose channel holds up to buffer events.
+func NewChannelSink(buffer int) *ChannelSink {
+	return &ChannelSink{C: make(chan Event, buffer)}
+}
+
+// Publish sends event on the channel, blocking until it is accepted or ctx is done.
+func (s *ChannelSink) Publish(ctx context.Context, event Event) error {
+	select {
+	case s.C <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newEvent creates an event of the given type for a session.
+func newEvent(eventType EventType, session SessionInfo) Event {
+	return Event{
+		Type:             eventType,
+		UploadID:         session.UploadID,
+		FileName:         session.FileName,
+		AdditionalParams: session.AdditionalParams,
+	}
+}
+
+// fileMetadataFrom extracts the typed file description from a metadata map
+// built by stitchFile.
+func fileMetadataFrom(metadata map[string]interface{}) *FileMetadata {
+	if metadata == nil {
+		return nil
+	}
+	file := &FileMetadata{}
+	file.OriginalName, _ = metadata["originalName"].(string)
+	file.StoredName, _ = metadata["storedName"].(string)
+	file.FileSize, _ = metadata["fileSize"].(int64)
+	file.MimeType, _ = metadata["mimeType"].(string)
+	file.Path, _ = metadata["path"].(string)
+	file.Owner, _ = metadata["owner"].(string)
+	file.DetectedMimeType, _ = metadata["detectedMimeType"].(string)
+	file.ScanVerdict, _ = metadata["scanVerdict"].(string)
+	file.Digest, _ = metadata["digest"].(string)
+	return file
+}
+
+// emit delivers an event to the configured OnEvent callback and EventSink.
+func (u *Uploader) emit(ctx context.Context, event Event) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if u.config.OnEvent != nil {
+		u.config.OnEvent(event)
+	}
+	if u.config.EventSink != nil {
+		if err := u.config.EventSink.Publish(ctx, event); err != nil {
+			u.config.Logger.Warn("failed to publish event", "event", event.Type, "uploadId", event.UploadID, "fileName", event.FileName, "error", err)
+		}
+	}
+}
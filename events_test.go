@@ -0,0 +1,98 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type failingSink struct{}
+
+func (failingSink) Publish(ctx context.Context, event Event) error {
+	return errors.New("bus unavailable")
+}
+
+func TestFanOut_PublishesToAllSinks(t *testing.T) {
+	dir := t.TempDir()
+	linesPath := filepath.Join(dir, "events.jsonl")
+
+	lines, err := NewJSONLinesSink(linesPath)
+	if err != nil {
+		t.Fatalf("Failed to create JSON-lines sink: %v", err)
+	}
+	channel := NewChannelSink(4)
+	fanOut := NewFanOut(failingSink{}, lines, channel)
+
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		EventSink: fanOut,
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":         "sink-upload",
+		"fileName":         "report.pdf",
+		"chunkIndex":       "0",
+		"totalChunks":      "1",
+		"fileSize":         "5",
+		"additionalParams": `{"userId":"123"}`,
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	lines.Close()
+
+	event := <-channel.C
+	if event.Type != EventCompleted || event.ID == "" {
+		t.Errorf("Expected completed event with an ID, got %+v", event)
+	}
+	if event.File == nil || event.File.OriginalName != "report.pdf" || event.File.FileSize != 5 || event.File.MimeType != "application/pdf" {
+		t.Errorf("Expected typed file metadata, got %+v", event.File)
+	}
+	if event.AdditionalParams["userId"] != "123" {
+		t.Errorf("Expected additionalParams in event, got %v", event.AdditionalParams)
+	}
+
+	f, err := os.Open(linesPath)
+	if err != nil {
+		t.Fatalf("Failed to open events file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("Expected an event line")
+	}
+	var written Event
+	if err := json.Unmarshal(scanner.Bytes(), &written); err != nil {
+		t.Fatalf("Invalid event line: %v", err)
+	}
+	if written.ID != event.ID || written.File.StoredName != event.File.StoredName {
+		t.Errorf("Expected the same event in both sinks, got %+v", written)
+	}
+}
+
+func TestFanOut_JoinsErrors(t *testing.T) {
+	channel := NewChannelSink(1)
+	err := NewFanOut(failingSink{}, channel).Publish(context.Background(), Event{Type: EventAborted})
+	if err == nil || err.Error() != "bus unavailable" {
+		t.Errorf("Expected sink error, got %v", err)
+	}
+	if len(channel.C) != 1 {
+		t.Error("A failing sink should not stop delivery to the others")
+	}
+}
+
+func TestChannelSink_RespectsContext(t *testing.T) {
+	channel := NewChannelSink(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := channel.Publish(ctx, Event{Type: EventAborted}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP makes an Uploader an http.Handler. It runs Handle and writes the
+// result as JSON, or an {"error": ...} body with a matching status code.
+// Rate-limited requests get 429 Too Many Requests with a Retry-After header.
+func (u *Uploader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := u.Handle(r)
+	if err != nil {
+		var limited *RateLimitError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		}
+		writeJSON(w, statusCode(err), map[string]interface{}{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// statusCode maps an error from Handle to an HTTP status. Errors without a
+// more specific status are reported as bad requests.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrTokenExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrChunkConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrUploadAborted), errors.Is(err, ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInsufficientStorage):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrInfected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrScanFailed):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
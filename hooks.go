@@ -0,0 +1,103 @@
+package chunkeduploader
+
+import (
+	"context"
+	"time"
+)
+
+// SessionInfo describes the upload session a hook is called for.
+type SessionInfo struct {
+	UploadID         string                 `json:"uploadId,omitempty"`
+	FileName         string                 `json:"fileName"`
+	FileSize         int64                  `json:"fileSize"`
+	TotalChunks      int                    `json:"totalChunks"`
+	AdditionalParams map[string]interface{} `json:"additionalParams,omitempty"`
+
+	// Owner and Prefix come from the upload token, if tokens are enabled.
+	Owner  string `json:"owner,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// key returns the key the session is stored under: its uploadId, or its file
+// name for uploads sent without one.
+func (info SessionInfo) key() string {
+	if info.UploadID != "" {
+		return info.UploadID
+	}
+	return info.FileName
+}
+
+// Hooks are application callbacks run at each stage of an upload. Every hook
+// receives the context of the operation it runs in, bounded by
+// Timeouts.Hook when set. Unset hooks are skipped.
+type Hooks struct {
+	// OnChunkReceived runs once a chunk has been staged, before it is added to
+	// its session. Returning an error rejects the chunk and discards it.
+	OnChunkReceived func(ctx context.Context, session SessionInfo, chunk ChunkInfo) error
+
+	// OnComplete runs after the file has been assembled, before the upload is
+	// reported complete. It may add fields to metadata. Returning an error
+	// vetoes finalization: the assembled file is deleted and the upload fails.
+	OnComplete func(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error
+
+	// OnError runs when a chunk is rejected or the upload fails to assemble.
+	OnError func(ctx context.Context, session SessionInfo, err error)
+
+	// OnAbort runs after an upload has been aborted and its chunks deleted.
+	OnAbort func(ctx context.Context, session SessionInfo)
+}
+
+// withTimeout derives a context that expires after d, or returns ctx
+// unchanged if d is not positive.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+func (u *Uploader) hookChunkReceived(ctx context.Context, session SessionInfo, chunk ChunkInfo) error {
+	if u.config.Hooks.OnChunkReceived == nil {
+		return nil
+	}
+	ctx, span := u.startHookSpan(ctx, "OnChunkReceived")
+	ctx, cancel := withTimeout(ctx, u.config.Timeouts.Hook)
+	defer cancel()
+	err := u.config.Hooks.OnChunkReceived(ctx, session, chunk)
+	endSpan(span, err)
+	return err
+}
+
+func (u *Uploader) hookComplete(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error {
+	if u.config.Hooks.OnComplete == nil {
+		return nil
+	}
+	ctx, span := u.startHookSpan(ctx, "OnComplete")
+	ctx, cancel := withTimeout(ctx, u.config.Timeouts.Hook)
+	defer cancel()
+	err := u.config.Hooks.OnComplete(ctx, session, metadata)
+	endSpan(span, err)
+	return err
+}
+
+func (u *Uploader) hookError(ctx context.Context, session SessionInfo, err error) {
+	if u.config.Hooks.OnError == nil {
+		return
+	}
+	ctx, span := u.startHookSpan(ctx, "OnError")
+	defer span.End()
+	ctx, cancel := withTimeout(ctx, u.config.Timeouts.Hook)
+	defer cancel()
+	u.config.Hooks.OnError(ctx, session, err)
+}
+
+func (u *Uploader) hookAbort(ctx context.Context, session SessionInfo) {
+	if u.config.Hooks.OnAbort == nil {
+		return
+	}
+	ctx, span := u.startHookSpan(ctx, "OnAbort")
+	defer span.End()
+	ctx, cancel := withTimeout(ctx, u.config.Timeouts.Hook)
+	defer cancel()
+	u.config.Hooks.OnAbort(ctx, session)
+}
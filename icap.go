@@ -0,0 +1,343 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ICAPMode selects how files are presented to an ICAP server.
+type ICAPMode string
+
+const (
+	ICAPRespmod ICAPMode = "RESPMOD" // as the body of an HTTP response, typical for antivirus
+	ICAPReqmod  ICAPMode = "REQMOD"  // as the body of an HTTP upload request, typical for DLP
+)
+
+// ICAPScanner is a Scanner that sends files to an ICAP server (RFC 3507).
+// The server's answer is mapped to a verdict: 204 No Content accepts the
+// file; a 200 carrying an HTTP error response (or, for REQMOD, any HTTP
+// response) blocks it, reported as VerdictInfected; a 200 carrying content
+// modifies it, and the file is replaced with the server's version.
+type ICAPScanner struct {
+	URL     string        // icap://host[:port]/service, port 1344 by default
+	Mode    ICAPMode      // default ICAPRespmod
+	Preview int           // bytes sent before the server decides whether it needs the rest, 0 to send everything
+	Timeout time.Duration // limit for one scan, default one minute
+}
+
+// Scan sends the file at path to the ICAP server and interprets its answer.
+func (s *ICAPScanner) Scan(ctx context.Context, path string) (ScanResult, error) {
+	target, err := url.Parse(s.URL)
+	if err != nil || target.Scheme != "icap" || target.Host == "" {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("invalid ICAP URL %q", s.URL)
+	}
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "1344")
+	}
+	mode := s.Mode
+	if mode == "" {
+		mode = ICAPRespmod
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error opening file for scanning: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error opening file for scanning: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error connecting to ICAP server: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	session := &icapSession{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		url:     target,
+		mode:    mode,
+		preview: s.Preview,
+		path:    path,
+	}
+	result, err := session.scan(f, filepath.Base(path), info.Size())
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return ScanResult{Verdict: VerdictError}, fmt.Errorf("error scanning with ICAP server: %w", err)
+	}
+	if result.modified != "" {
+		if err := os.Rename(result.modified, path); err != nil {
+			os.Remove(result.modified)
+			return ScanResult{Verdict: VerdictError}, fmt.Errorf("error writing modified file: %v", err)
+		}
+		return ScanResult{Verdict: VerdictClean, Modified: true}, nil
+	}
+	return result.ScanResult, nil
+}
+
+// maxICAPHeaders bounds the encapsulated HTTP headers of an ICAP response.
+const maxICAPHeaders = 64 << 10
+
+// icapSession is one request/response exchange with an ICAP server.
+type icapSession struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	url     *url.URL
+	mode    ICAPMode
+	preview int
+	path    string // the file being scanned
+}
+
+type icapResult struct {
+	ScanResult
+	modified string // temporary file with the replacement content, if the server modified the file
+}
+
+func (s *icapSession) scan(body io.Reader, name string, size int64) (icapResult, error) {
+	// The file is wrapped in the HTTP message an ICAP server expects for the mode
+	contentType := declaredType(name)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	requestLine := "GET /" + url.PathEscape(name) + " HTTP/1.1\r\nHost: " + s.url.Hostname() + "\r\n\r\n"
+	var encapsulated, httpHeaders string
+	if s.mode == ICAPReqmod {
+		httpHeaders = fmt.Sprintf("POST /%s HTTP/1.1\r\nHost: %s\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", url.PathEscape(name), s.url.Hostname(), contentType, size)
+		encapsulated = fmt.Sprintf("req-hdr=0, req-body=%d", len(httpHeaders))
+	} else {
+		responseHeaders := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, size)
+		httpHeaders = requestLine + responseHeaders
+		encapsulated = fmt.Sprintf("req-hdr=0, res-hdr=%d, res-body=%d", len(requestLine), len(httpHeaders))
+	}
+
+	fmt.Fprintf(s.w, "%s %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\n", s.mode, s.url.String(), s.url.Host)
+	if s.preview > 0 {
+		fmt.Fprintf(s.w, "Preview: %d\r\n", s.preview)
+	}
+	fmt.Fprintf(s.w, "Encapsulated: %s\r\n\r\n%s", encapsulated, httpHeaders)
+
+	if s.preview > 0 {
+		previewLen := min(int64(s.preview), size)
+		if err := writeICAPChunks(s.w, io.LimitReader(body, previewLen)); err != nil {
+			return icapResult{}, err
+		}
+		// ieof tells the server the preview is the whole file
+		if previewLen == size {
+			s.w.WriteString("0; ieof\r\n\r\n")
+		} else {
+			s.w.WriteString("0\r\n\r\n")
+		}
+		if err := s.w.Flush(); err != nil {
+			return icapResult{}, err
+		}
+		if previewLen == size {
+			return s.readResponse()
+		}
+
+		status, header, err := s.readStatus()
+		if err != nil {
+			return icapResult{}, err
+		}
+		if status != 100 {
+			// The server decided on the preview alone
+			return s.interpret(status, header)
+		}
+	}
+
+	if err := writeICAPChunks(s.w, body); err != nil {
+		return icapResult{}, err
+	}
+	s.w.WriteString("0\r\n\r\n")
+	if err := s.w.Flush(); err != nil {
+		return icapResult{}, err
+	}
+	return s.readResponse()
+}
+
+// writeICAPChunks copies r to w in HTTP chunked encoding, without the final
+// zero-length chunk.
+func writeICAPChunks(w *bufio.Writer, r io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			if _, err := w.WriteString("\r\n"); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *icapSession) readResponse() (icapResult, error) {
+	status, header, err := s.readStatus()
+	if err != nil {
+		return icapResult{}, err
+	}
+	return s.interpret(status, header)
+}
+
+// readStatus reads an ICAP status line and headers.
+func (s *icapSession) readStatus() (int, textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(s.r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return 0, nil, err
+	}
+	version, rest, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(rest, " ")
+	status, err := strconv.Atoi(code)
+	if !strings.HasPrefix(version, "ICAP/") || err != nil {
+		return 0, nil, fmt.Errorf("malformed ICAP status line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	return status, header, nil
+}
+
+// interpret maps an ICAP response to accept, block or modify.
+func (s *icapSession) interpret(status int, header textproto.MIMEHeader) (icapResult, error) {
+	switch status {
+	case 204:
+		return icapResult{ScanResult: ScanResult{Verdict: VerdictClean}}, nil
+	case 200:
+	default:
+		return icapResult{}, fmt.Errorf("ICAP server returned status %d", status)
+	}
+
+	sections, err := parseEncapsulated(header.Get("Encapsulated"))
+	if err != nil {
+		return icapResult{}, err
+	}
+
+	// Read the encapsulated HTTP headers, up to the body offset
+	var headerLen int
+	var bodySection string
+	for _, section := range sections {
+		if strings.HasSuffix(section.name, "-body") {
+			headerLen, bodySection = section.offset, section.name
+		}
+	}
+	if headerLen > maxICAPHeaders {
+		return icapResult{}, fmt.Errorf("encapsulated headers too large: %d bytes", headerLen)
+	}
+	encapsulatedHeaders := make([]byte, headerLen)
+	if _, err := io.ReadFull(s.r, encapsulatedHeaders); err != nil {
+		return icapResult{}, fmt.Errorf("error reading encapsulated headers: %v", err)
+	}
+
+	// An HTTP response in answer to a request, or an HTTP error response, is
+	// what the server wants shown instead of the file
+	httpStatus := 0
+	if offset, ok := sectionOffset(sections, "res-hdr"); ok && offset <= len(encapsulatedHeaders) {
+		statusLine, _, _ := strings.Cut(string(encapsulatedHeaders[offset:]), "\r\n")
+		if fields := strings.Fields(statusLine); len(fields) >= 2 {
+			httpStatus, _ = strconv.Atoi(fields[1])
+		}
+		if s.mode == ICAPReqmod || httpStatus < 200 || httpStatus > 299 {
+			return icapResult{ScanResult: ScanResult{Verdict: VerdictInfected, Signature: icapThreat(header, httpStatus)}}, nil
+		}
+	}
+	// Without a body there is nothing to replace the file with
+	if bodySection == "" || bodySection == "null-body" {
+		return icapResult{ScanResult: ScanResult{Verdict: VerdictClean}}, nil
+	}
+
+	// The modified content can be as large as the upload, so it is streamed
+	// to disk next to the file
+	temp := s.path + ".icap"
+	out, err := os.Create(temp)
+	if err != nil {
+		return icapResult{}, fmt.Errorf("error writing modified file: %v", err)
+	}
+	_, err = io.Copy(out, httputil.NewChunkedReader(s.r))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return icapResult{}, fmt.Errorf("error reading encapsulated body: %v", err)
+	}
+	return icapResult{ScanResult: ScanResult{Verdict: VerdictClean}, modified: temp}, nil
+}
+
+type encapsulatedSection struct {
+	name   string
+	offset int
+}
+
+// parseEncapsulated parses an Encapsulated header such as
+// "res-hdr=0, res-body=137".
+func parseEncapsulated(value string) ([]encapsulatedSection, error) {
+	var sections []encapsulatedSection
+	for _, part := range strings.Split(value, ",") {
+		name, offset, found := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(offset)
+		if !found || err != nil || n < 0 {
+			return nil, fmt.Errorf("malformed Encapsulated header %q", value)
+		}
+		sections = append(sections, encapsulatedSection{name: name, offset: n})
+	}
+	return sections, nil
+}
+
+func sectionOffset(sections []encapsulatedSection, name string) (int, bool) {
+	for _, section := range sections {
+		if section.name == name {
+			return section.offset, true
+		}
+	}
+	return 0, false
+}
+
+// icapThreat describes why a server blocked a file, from the headers commonly
+// used for it.
+func icapThreat(header textproto.MIMEHeader, httpStatus int) string {
+	for _, name := range []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-Id", "X-Blocked-Reason"} {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return fmt.Sprintf("blocked by ICAP server (HTTP %d)", httpStatus)
+}
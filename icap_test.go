@@ -0,0 +1,270 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// icapExchange records what the stub ICAP server received.
+type icapExchange struct {
+	method string
+	body   []byte
+	ieof   bool // the preview was the whole body
+}
+
+// startICAPStub serves RESPMOD and REQMOD requests. It blocks bodies that
+// contain the EICAR test string, redacts "SECRET", answers a 200 without a
+// body for "UNCHANGED", breaks off a modified body for "TRUNCATE", answers 204 on a preview containing "ALLOW" without
+// asking for the rest, and accepts anything else.
+func startICAPStub(t *testing.T) (string, chan icapExchange) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	exchanges := make(chan icapExchange, 16)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				tp := textproto.NewReader(r)
+				line, err := tp.ReadLine()
+				if err != nil {
+					return
+				}
+				method, _, _ := strings.Cut(line, " ")
+				header, err := tp.ReadMIMEHeader()
+				if err != nil {
+					return
+				}
+				sections, err := parseEncapsulated(header.Get("Encapsulated"))
+				if err != nil {
+					conn.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n"))
+					return
+				}
+				bodyOffset := sections[len(sections)-1].offset
+				if _, err := io.CopyN(io.Discard, r, int64(bodyOffset)); err != nil {
+					return
+				}
+
+				exchange := icapExchange{method: method}
+				var body bytes.Buffer
+				exchange.ieof = readStubChunks(r, &body)
+				if header.Get("Preview") != "" && !exchange.ieof {
+					if bytes.Contains(body.Bytes(), []byte("ALLOW")) {
+						exchange.body = body.Bytes()
+						exchanges <- exchange
+						conn.Write([]byte("ICAP/1.0 204 No Content\r\n\r\n"))
+						return
+					}
+					conn.Write([]byte("ICAP/1.0 100 Continue\r\n\r\n"))
+					readStubChunks(r, &body)
+				}
+				exchange.body = body.Bytes()
+				exchanges <- exchange
+
+				switch {
+				case bytes.Contains(exchange.body, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")):
+					page := "blocked"
+					httpHeaders := "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\n\r\n"
+					fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s%x\r\n%s\r\n0\r\n\r\n", len(httpHeaders), httpHeaders, len(page), page)
+				case bytes.Contains(exchange.body, []byte("SECRET")):
+					redacted := bytes.ReplaceAll(exchange.body, []byte("SECRET"), []byte("[REDACTED]"))
+					section, httpHeaders := "res", "HTTP/1.1 200 OK\r\n\r\n"
+					if method == "REQMOD" {
+						section, httpHeaders = "req", "POST /upload HTTP/1.1\r\n\r\n"
+					}
+					fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nEncapsulated: %s-hdr=0, %s-body=%d\r\n\r\n%s%x\r\n%s\r\n0\r\n\r\n", section, section, len(httpHeaders), httpHeaders, len(redacted), redacted)
+				case bytes.Contains(exchange.body, []byte("TRUNCATE")):
+					httpHeaders := "HTTP/1.1 200 OK\r\n\r\n"
+					fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s100\r\npartial", len(httpHeaders), httpHeaders)
+				case bytes.Contains(exchange.body, []byte("UNCHANGED")):
+					httpHeaders := "HTTP/1.1 200 OK\r\n\r\n"
+					fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=%d\r\n\r\n%s", len(httpHeaders), httpHeaders)
+				default:
+					conn.Write([]byte("ICAP/1.0 204 No Content\r\n\r\n"))
+				}
+			}(conn)
+		}
+	}()
+	return "icap://" + listener.Addr().String() + "/scan", exchanges
+}
+
+// readStubChunks reads a chunked body up to its last chunk and reports
+// whether that chunk carried the ieof extension.
+func readStubChunks(r *bufio.Reader, body *bytes.Buffer) bool {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		size, extension, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return false
+		}
+		if n == 0 {
+			r.ReadString('\n')
+			return strings.TrimSpace(extension) == "ieof"
+		}
+		io.CopyN(body, r, n)
+		r.ReadString('\n')
+	}
+}
+
+func TestICAPScanner_Scan(t *testing.T) {
+	url, exchanges := startICAPStub(t)
+	dir := t.TempDir()
+
+	clean := filepath.Join(dir, "clean.txt")
+	os.WriteFile(clean, []byte("Hello, World!"), 0644)
+	scanner := &ICAPScanner{URL: url}
+	if result, err := scanner.Scan(context.Background(), clean); err != nil || result.Verdict != VerdictClean || result.Modified {
+		t.Errorf("Expected clean verdict, got %+v, %v", result, err)
+	}
+	if exchange := <-exchanges; exchange.method != "RESPMOD" || string(exchange.body) != "Hello, World!" {
+		t.Errorf("Expected the file as a RESPMOD body, got %+v", exchange)
+	}
+
+	infected := filepath.Join(dir, "eicar.com")
+	os.WriteFile(infected, []byte(eicar), 0644)
+	result, err := (&ICAPScanner{URL: url, Preview: 8}).Scan(context.Background(), infected)
+	if err != nil || result.Verdict != VerdictInfected || !strings.Contains(result.Signature, "Eicar-Test-Signature") {
+		t.Errorf("Expected blocked file reported as infected, got %+v, %v", result, err)
+	}
+	if exchange := <-exchanges; string(exchange.body) != eicar {
+		t.Errorf("Expected the rest of the body after 100 Continue, got %q", exchange.body)
+	}
+	if content, _ := os.ReadFile(infected); string(content) != eicar {
+		t.Errorf("A blocked file should not be modified, got %q", content)
+	}
+
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	os.WriteFile(unchanged, []byte("UNCHANGED content"), 0644)
+	if result, err := scanner.Scan(context.Background(), unchanged); err != nil || result.Verdict != VerdictClean || result.Modified {
+		t.Errorf("Expected a 200 without a body to leave the file unmodified, got %+v, %v", result, err)
+	}
+	<-exchanges
+	if content, _ := os.ReadFile(unchanged); string(content) != "UNCHANGED content" {
+		t.Errorf("Expected the file to keep its content, got %q", content)
+	}
+
+	unreachable := &ICAPScanner{URL: "icap://127.0.0.1:1/scan"}
+	if result, err := unreachable.Scan(context.Background(), clean); err == nil || result.Verdict != VerdictError {
+		t.Errorf("Expected error verdict for unreachable server, got %+v, %v", result, err)
+	}
+}
+
+func TestICAPScanner_Preview(t *testing.T) {
+	url, exchanges := startICAPStub(t)
+	dir := t.TempDir()
+	scanner := &ICAPScanner{URL: url, Preview: 8}
+
+	// The server accepts on the preview alone
+	allowed := filepath.Join(dir, "allowed.txt")
+	os.WriteFile(allowed, []byte("ALLOW this file without reading the rest"), 0644)
+	if result, err := scanner.Scan(context.Background(), allowed); err != nil || result.Verdict != VerdictClean {
+		t.Errorf("Expected clean verdict from the preview, got %+v, %v", result, err)
+	}
+	if exchange := <-exchanges; string(exchange.body) != "ALLOW th" || exchange.ieof {
+		t.Errorf("Expected only the preview to be sent, got %+v", exchange)
+	}
+
+	// The whole file fits in the preview
+	small := filepath.Join(dir, "small.txt")
+	os.WriteFile(small, []byte("tiny"), 0644)
+	if result, err := scanner.Scan(context.Background(), small); err != nil || result.Verdict != VerdictClean {
+		t.Errorf("Expected clean verdict, got %+v, %v", result, err)
+	}
+	if exchange := <-exchanges; string(exchange.body) != "tiny" || !exchange.ieof {
+		t.Errorf("Expected the preview to end with ieof, got %+v", exchange)
+	}
+}
+
+func TestICAPScanner_Modify(t *testing.T) {
+	url, exchanges := startICAPStub(t)
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("the SECRET plan"), 0644)
+
+	result, err := (&ICAPScanner{URL: url, Mode: ICAPReqmod}).Scan(context.Background(), path)
+	if err != nil || result.Verdict != VerdictClean || !result.Modified {
+		t.Fatalf("Expected modified clean verdict, got %+v, %v", result, err)
+	}
+	if exchange := <-exchanges; exchange.method != "REQMOD" {
+		t.Errorf("Expected a REQMOD request, got %s", exchange.method)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "the [REDACTED] plan" {
+		t.Errorf("Expected the file to be replaced with the modified content, got %q", content)
+	}
+
+	// Large modified bodies are streamed to disk
+	large := bytes.Repeat([]byte("public SECRET "), 1<<16)
+	os.WriteFile(path, large, 0644)
+	if result, err := (&ICAPScanner{URL: url}).Scan(context.Background(), path); err != nil || !result.Modified {
+		t.Fatalf("Expected modified clean verdict, got %+v, %v", result, err)
+	}
+	<-exchanges
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, bytes.ReplaceAll(large, []byte("SECRET"), []byte("[REDACTED]"))) {
+		t.Errorf("Expected the large file to be replaced with the modified content, got %d bytes", len(content))
+	}
+
+	// A body that breaks off leaves the file as it was
+	os.WriteFile(path, []byte("TRUNCATE me"), 0644)
+	if result, err := (&ICAPScanner{URL: url}).Scan(context.Background(), path); err == nil || result.Verdict != VerdictError {
+		t.Errorf("Expected error verdict for a truncated body, got %+v, %v", result, err)
+	}
+	<-exchanges
+	if content, _ := os.ReadFile(path); string(content) != "TRUNCATE me" {
+		t.Errorf("Expected the file to keep its content, got %q", content)
+	}
+	if _, err := os.Stat(path + ".icap"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file to be left behind, got %v", err)
+	}
+}
+
+func TestUploader_ICAPModifiedFile(t *testing.T) {
+	url, _ := startICAPStub(t)
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:       filepath.Join(dir, "chunks"),
+		UploadDir:     filepath.Join(dir, "uploads"),
+		QuarantineDir: filepath.Join(dir, "quarantine"),
+		Scanner:       &ICAPScanner{URL: url},
+	})
+
+	data := "card SECRET"
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    "card.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    fmt.Sprint(len(data)),
+	}, []byte(data))
+	result, err := u.Handle(req)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	metadata := result["metadata"].(map[string]interface{})
+	if metadata["scanModified"] != true || metadata["fileSize"] != int64(len("card [REDACTED]")) {
+		t.Errorf("Expected metadata of the modified file, got %v", metadata)
+	}
+	if content, _ := os.ReadFile(metadata["path"].(string)); string(content) != "card [REDACTED]" {
+		t.Errorf("Expected the stored file to be modified, got %q", content)
+	}
+}
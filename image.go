@@ -0,0 +1,147 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrImageTooLarge is returned by ImageProcessor for images with more pixels
+// than it allows.
+var ErrImageTooLarge = errors.New("image too large")
+
+// ImageProcessor is a Processor for PNG, JPEG, GIF and WebP uploads. It
+// records imageWidth, imageHeight and imageFormat in the metadata and writes
+// thumbnails next to the stored file. Other files are left alone.
+type ImageProcessor struct {
+	Thumbnails []ThumbnailSize // thumbnails to generate, none if empty
+
+	// MaxPixels rejects images whose header declares more pixels, before
+	// any of them are decoded. Default 40 million.
+	MaxPixels int64
+
+	JPEGQuality int // quality of JPEG thumbnails, default 85
+}
+
+// ThumbnailSize is a box a thumbnail is scaled to fit in, keeping the
+// image's aspect ratio. Images smaller than the box are not enlarged.
+type ThumbnailSize struct {
+	Name   string // suffix of the thumbnail file, for example "small"
+	Width  int
+	Height int
+}
+
+// Process implements Processor.
+func (p *ImageProcessor) Process(ctx context.Context, file *ProcessedFile) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("error opening image: %v", err)
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if errors.Is(err, image.ErrFormat) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading image header: %v", err)
+	}
+
+	maxPixels := p.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = 40_000_000
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return fmt.Errorf("error reading image header: invalid dimensions %dx%d", config.Width, config.Height)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, config.Width, config.Height, maxPixels)
+	}
+	file.Metadata["imageWidth"] = config.Width
+	file.Metadata["imageHeight"] = config.Height
+	file.Metadata["imageFormat"] = format
+
+	if len(p.Thumbnails) == 0 {
+		return nil
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return fmt.Errorf("error reading image: %v", err)
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("error decoding image: %v", err)
+	}
+
+	thumbnails := make(map[string]string)
+	base := strings.TrimSuffix(file.Path, filepath.Ext(file.Path))
+	for _, size := range p.Thumbnails {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ext := ".png"
+		if format == "jpeg" {
+			ext = ".jpg"
+		}
+		path := base + "_" + size.Name + ext
+		if err := p.writeThumbnail(path, thumbnail(img, size)); err != nil {
+			return err
+		}
+		file.AddDerived("thumbnail", path)
+		thumbnails[size.Name] = path
+	}
+	file.Metadata["thumbnails"] = thumbnails
+	return nil
+}
+
+// thumbnail scales img down to fit in size.
+func thumbnail(img image.Image, size ThumbnailSize) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if size.Width > 0 && width > size.Width {
+		height = max(1, height*size.Width/width)
+		width = size.Width
+	}
+	if size.Height > 0 && height > size.Height {
+		width = max(1, width*size.Height/height)
+		height = size.Height
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// writeThumbnail encodes img as JPEG or PNG, depending on the extension of path.
+func (p *ImageProcessor) writeThumbnail(path string, img image.Image) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating thumbnail: %v", err)
+	}
+	if filepath.Ext(path) == ".jpg" {
+		quality := p.JPEGQuality
+		if quality <= 0 {
+			quality = 85
+		}
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(out, img)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error writing thumbnail: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageProcessor_Process(t *testing.T) {
+	u, _, _ := processingUploader(t, ProcessingStep{Name: "images", Processor: &ImageProcessor{
+		Thumbnails: []ThumbnailSize{{Name: "small", Width: 40, Height: 40}, {Name: "large", Width: 400, Height: 400}},
+	}})
+
+	result, err := uploadSingleChunk(u, "photo.png", string(encodePNG(t, 200, 100)))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	metadata := result["metadata"].(map[string]interface{})
+	if metadata["imageWidth"] != 200 || metadata["imageHeight"] != 100 || metadata["imageFormat"] != "png" {
+		t.Errorf("Expected image dimensions in metadata, got %v", metadata)
+	}
+
+	thumbnails := metadata["thumbnails"].(map[string]string)
+	small, err := os.Open(thumbnails["small"])
+	if err != nil {
+		t.Fatalf("Expected small thumbnail: %v", err)
+	}
+	defer small.Close()
+	if config, _, err := image.DecodeConfig(small); err != nil || config.Width != 40 || config.Height != 20 {
+		t.Errorf("Expected a 40x20 thumbnail, got %+v, %v", config, err)
+	}
+	large, _ := os.ReadFile(thumbnails["large"])
+	if config, _, err := image.DecodeConfig(bytes.NewReader(large)); err != nil || config.Width != 200 {
+		t.Errorf("Thumbnails should not enlarge images, got %+v, %v", config, err)
+	}
+	if filepath.Dir(thumbnails["small"]) != filepath.Dir(metadata["path"].(string)) {
+		t.Errorf("Expected thumbnails next to the stored file, got %s", thumbnails["small"])
+	}
+}
+
+func TestImageProcessor_JPEGAndOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	processor := &ImageProcessor{Thumbnails: []ThumbnailSize{{Name: "thumb", Width: 10}}}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 60)), nil)
+	path := filepath.Join(dir, "photo.jpg")
+	os.WriteFile(path, buf.Bytes(), 0644)
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	if err := processor.Process(context.Background(), file); err != nil {
+		t.Fatalf("Processing failed: %v", err)
+	}
+	if file.Metadata["imageFormat"] != "jpeg" || len(file.Derived) != 1 || filepath.Ext(file.Derived[0].Path) != ".jpg" {
+		t.Errorf("Expected a JPEG thumbnail, got %v, %v", file.Metadata, file.Derived)
+	}
+
+	text := filepath.Join(dir, "notes.txt")
+	os.WriteFile(text, []byte("not an image"), 0644)
+	file = &ProcessedFile{Path: text, Metadata: map[string]interface{}{}}
+	if err := processor.Process(context.Background(), file); err != nil || len(file.Metadata) != 0 {
+		t.Errorf("Expected other files to be skipped, got %v, %v", file.Metadata, err)
+	}
+}
+
+func TestImageProcessor_DecompressionBomb(t *testing.T) {
+	// A PNG whose header declares 100000x100000 pixels, with no pixel data
+	var header bytes.Buffer
+	header.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:], 100000)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+	binary.Write(&header, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	header.Write(chunk)
+	binary.Write(&header, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	path := filepath.Join(t.TempDir(), "bomb.png")
+	os.WriteFile(path, header.Bytes(), 0644)
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	err := (&ImageProcessor{Thumbnails: []ThumbnailSize{{Name: "thumb", Width: 10}}}).Process(context.Background(), file)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge, got %v", err)
+	}
+}
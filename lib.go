@@ -3,28 +3,40 @@
 package chunkeduploader
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// stitchProgressInterval is how many bytes stitchFile copies between progress
+// reports within a chunk; it always reports at the end of each chunk.
+const stitchProgressInterval = 16 << 20
+
 // Stitches together file chunks into a single file.
 // It creates a new file with a GUID as the name, and returns metadata about the stitched file.
-func stitchFile(fileName string, expectedSize int64) (map[string]interface{}, error) {
-	chunks := fileManager.GetChunks(fileName)
-
+// Progress is passed to report, if set, as chunks processed and bytes written so far.
+// When ctx is cancelled the copy stops and the partial file is removed.
+func (u *Uploader) stitchFile(ctx context.Context, prefix string, fileName string, chunks []string, expectedSize int64, report func(chunksDone int, bytesWritten int64)) (metadata map[string]interface{}, err error) {
 	// Create uploads directory
-	uploadsDir := "./uploads"
-	err := os.MkdirAll(uploadsDir, 0755)
+	uploadsDir := filepath.Join(u.config.UploadDir, filepath.FromSlash(prefix))
+	err = os.MkdirAll(uploadsDir, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("error creating uploads directory: %v", err)
 	}
@@ -33,15 +45,28 @@ func stitchFile(fileName string, expectedSize int64) (map[string]interface{}, er
 	ext := filepath.Ext(fileName)
 	guid := uuid.New().String()
 	storedName := guid + ext
-	finalPath := filepath.Join(uploadsDir, storedName)
+	// The file is written under a hidden staging name and only gets its
+	// stored name from publishFile, once it has been scanned
+	stagingPath := filepath.Join(uploadsDir, "."+storedName+".part")
 
-	finalFile, err := os.Create(finalPath)
+	finalFile, err := os.Create(stagingPath)
 	if err != nil {
 		return nil, fmt.Errorf("error creating final file: %v", err)
 	}
-	defer finalFile.Close()
+	// Never leave a partial file behind, whatever the reason for failing
+	defer func() {
+		finalFile.Close()
+		if err != nil {
+			os.Remove(stagingPath)
+		}
+	}()
+
+	if report == nil {
+		report = func(int, int64) {}
+	}
 
 	var totalWritten int64
+	buf := make([]byte, 1<<20)
 
 	for i, chunkPath := range chunks {
 		if chunkPath == "" {
@@ -53,18 +78,28 @@ func stitchFile(fileName string, expectedSize int64) (map[string]interface{}, er
 			return nil, fmt.Errorf("error opening chunk %d: %v", i, err)
 		}
 
-		written, err := io.Copy(finalFile, chunkFile)
+		base, lastReported := totalWritten, totalWritten
+		src := &countingReader{r: contextReader{ctx: ctx, r: chunkFile}, onRead: func(n int64) {
+			if base+n-lastReported >= stitchProgressInterval {
+				lastReported = base + n
+				report(i, lastReported)
+			}
+		}}
+		written, err := io.CopyBuffer(finalFile, src, buf)
 		chunkFile.Close()
 
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("stitching cancelled: %w", ctxErr)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error copying chunk %d: %v", i, err)
 		}
 
 		totalWritten += written
+		report(i+1, totalWritten)
 	}
 
 	if totalWritten != expectedSize {
-		os.Remove(finalPath)
 		return nil, fmt.Errorf("file size mismatch: expected %d, got %d", expectedSize, totalWritten)
 	}
 
@@ -74,46 +109,61 @@ func stitchFile(fileName string, expectedSize int64) (map[string]interface{}, er
 		mimeType = "application/octet-stream"
 	}
 
-	metadata := map[string]interface{}{
+	metadata = map[string]interface{}{
 		"status":       "complete",
 		"originalName": fileName,
 		"storedName":   storedName,
 		"fileSize":     totalWritten,
 		"mimeType":     mimeType,
-		"path":         finalPath,
+		"path":         stagingPath,
 	}
 
-	log.Printf("Successfully stitched file: %s => %s (size: %d bytes)", fileName, storedName, totalWritten)
 	return metadata, nil
 }
 
+// publishFile moves a stitched file from its staging name to its stored name,
+// making it visible in the upload directory.
+func publishFile(metadata map[string]interface{}) error {
+	stagingPath := metadata["path"].(string)
+	finalPath := filepath.Join(filepath.Dir(stagingPath), metadata["storedName"].(string))
+	if err := os.Rename(stagingPath, finalPath); err != nil {
+		os.Remove(stagingPath)
+		return fmt.Errorf("error publishing file: %v", err)
+	}
+	metadata["path"] = finalPath
+	return nil
+}
+
 // cleanupChunks deletes all chunks associated with a file and removes the file from the file manager.
 // It logs the success or failure of each deletion.
-func cleanupChunks(fileName string) {
-	chunks := fileManager.GetChunks(fileName)
+func (u *Uploader) cleanupChunks(fileName string) {
+	u.removeChunkFiles(SessionInfo{FileName: fileName}, u.files.GetChunks(fileName))
+	u.files.RemoveFile(fileName)
+}
 
+// removeChunkFiles deletes the given chunk files of an upload from disk.
+func (u *Uploader) removeChunkFiles(info SessionInfo, chunks []string) {
+	logger := u.sessionLogger(info)
 	for i, chunkPath := range chunks {
 		if chunkPath != "" {
 			err := os.Remove(chunkPath)
 			if err != nil {
-				log.Printf("Failed to delete chunk %d (%s): %v", i, chunkPath, err)
+				logger.Warn("failed to delete chunk", "chunkIndex", i, "path", chunkPath, "error", err)
 			} else {
-				log.Printf("Successfully deleted chunk: %s", chunkPath)
+				logger.Debug("deleted chunk", "chunkIndex", i, "path", chunkPath)
 			}
 		}
 	}
-
-	fileManager.RemoveFile(fileName)
 }
 
-func parseAdditionalParams(additionalParamsStr string) map[string]interface{} {
+func (u *Uploader) parseAdditionalParams(additionalParamsStr string) map[string]interface{} {
 	if additionalParamsStr == "" {
 		return map[string]interface{}{}
 	}
 
 	var additionalParams map[string]interface{}
 	if err := json.Unmarshal([]byte(additionalParamsStr), &additionalParams); err != nil {
-		log.Printf("Warning: Failed to parse additionalParams as JSON: %v", err)
+		u.config.Logger.Warn("failed to parse additionalParams as JSON", "error", err)
 		return map[string]interface{}{}
 	}
 
@@ -122,20 +172,390 @@ func parseAdditionalParams(additionalParamsStr string) map[string]interface{} {
 
 func NewFileManager() *FileManager {
 	return &FileManager{
-		chunks: make(map[string][]string),
+		sessions: make(map[string]*uploadSession),
 	}
 }
 
+// sessionLocked returns the session stored under key, creating it if needed.
+// The caller must hold fm.mutex for writing.
+func (fm *FileManager) sessionLocked(key string, info SessionInfo) *uploadSession {
+	s, exists := fm.sessions[key]
+	if !exists {
+		s = &uploadSession{
+			info:      info,
+			chunks:    make([]chunkRecord, info.TotalChunks),
+			state:     StateReceiving,
+			done:      make(chan struct{}),
+			updatedAt: time.Now(),
+		}
+		fm.sessions[key] = s
+	}
+	return s
+}
+
 // AddChunk adds a file chunk to the file manager.
-// It initializes the chunk list for the file if it doesn't exist.
+// It initializes the chunk list for the file if it doesn't exist. Chunks for
+// sessions that are no longer receiving are ignored.
 func (fm *FileManager) AddChunk(fileName string, chunkPath string, chunkIndex int, totalChunks int) {
 	fm.mutex.Lock()
 	defer fm.mutex.Unlock()
 
-	if _, exists := fm.chunks[fileName]; !exists {
-		fm.chunks[fileName] = make([]string, totalChunks)
+	s := fm.sessionLocked(fileName, SessionInfo{FileName: fileName, TotalChunks: totalChunks})
+	if s.state == StateReceiving {
+		s.chunks[chunkIndex] = chunkRecord{path: chunkPath}
+	}
+}
+
+// commitChunk moves a staged chunk into place and records it in one step.
+// If the chunk completes the file, the session moves to StateAssembling and
+// assemble is true; exactly one caller per session ever sees assemble set.
+// If the session is no longer receiving, the staged file is discarded and
+// errSessionSettled is returned with the session, whose outcome the caller
+// should wait for instead.
+//
+// A chunk whose index was already stored is compared by size and digest: an
+// identical retry is discarded and reported as errDuplicateChunk, while
+// different content is handled according to policy.
+func (fm *FileManager) commitChunk(key string, c incomingChunk, policy ConflictPolicy) (s *uploadSession, assemble bool, err error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	staged := c.staged
+	s = fm.sessionLocked(key, c.session)
+	if s.state != StateReceiving {
+		os.Remove(staged.path)
+		return s, false, errSessionSettled
+	}
+	if s.info.Owner != c.session.Owner {
+		os.Remove(staged.path)
+		return nil, false, fmt.Errorf("%w: upload belongs to another owner", ErrInvalidToken)
+	}
+	if len(s.chunks) != c.session.TotalChunks {
+		os.Remove(staged.path)
+		return nil, false, fmt.Errorf("totalChunks mismatch: expected %d, got %d", len(s.chunks), c.session.TotalChunks)
+	}
+
+	duplicate := false
+	if existing := s.chunks[c.chunkIndex]; existing.path != "" && existing.digest != "" {
+		if existing.size == staged.size && existing.digest == staged.digest {
+			os.Remove(staged.path)
+			duplicate = true
+		} else if policy != OverwriteConflictingChunks {
+			os.Remove(staged.path)
+			return nil, false, fmt.Errorf("%w: chunk %d", ErrChunkConflict, c.chunkIndex)
+		}
+	}
+
+	if !duplicate {
+		if err := os.Rename(staged.path, c.chunkPath); err != nil {
+			os.Remove(staged.path)
+			return nil, false, fmt.Errorf("error saving chunk: %v", err)
+		}
+		s.chunks[c.chunkIndex] = chunkRecord{path: c.chunkPath, size: staged.size, digest: staged.digest, contentType: staged.contentType}
+	}
+	s.info = c.session
+	s.updatedAt = time.Now()
+
+	// A duplicate can still complete a session whose assembly was rolled
+	// back, for example by a shutdown.
+	for _, chunk := range s.chunks {
+		if chunk.path == "" {
+			if duplicate {
+				return s, false, errDuplicateChunk
+			}
+			return s, false, nil
+		}
+	}
+	s.state = StateAssembling
+	return s, true, nil
+}
+
+// finishAssembly records the outcome of assembling a session and wakes any
+// callers waiting on it. The session is forgotten after retain has elapsed,
+// or immediately if retain is zero.
+func (fm *FileManager) finishAssembly(fileName string, s *uploadSession, result map[string]interface{}, err error, retain time.Duration) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	s.result = result
+	s.err = err
+	if err != nil {
+		s.state = StateFailed
+	} else {
+		s.state = StateComplete
+	}
+	close(s.done)
+	fm.forgetAfterLocked(fileName, s, retain)
+}
+
+// forgetAfterLocked schedules s to be forgotten after retain, or forgets it
+// immediately if retain is zero. The caller must hold fm.mutex for writing.
+func (fm *FileManager) forgetAfterLocked(key string, s *uploadSession, retain time.Duration) {
+	if retain <= 0 {
+		fm.forgetLocked(key, s)
+		return
+	}
+	time.AfterFunc(retain, func() {
+		fm.mutex.Lock()
+		defer fm.mutex.Unlock()
+		fm.forgetLocked(key, s)
+	})
+}
+
+// forgetLocked removes s from the manager if it is still the current session
+// for fileName. The caller must hold fm.mutex for writing.
+func (fm *FileManager) forgetLocked(fileName string, s *uploadSession) {
+	if fm.sessions[fileName] == s {
+		delete(fm.sessions, fileName)
+	}
+}
+
+// abort moves a receiving session to StateAborted so that later chunks are
+// rejected, and returns the session and its chunk paths for cleanup. The
+// aborted session is remembered as long as its retention allows.
+func (fm *FileManager) abort(key string) (*uploadSession, []string, error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	s, exists := fm.sessions[key]
+	if !exists {
+		return nil, nil, ErrUploadNotFound
+	}
+	if s.state == StateAborted {
+		return nil, nil, ErrUploadAborted
+	}
+	if s.state != StateReceiving {
+		return nil, nil, fmt.Errorf("cannot abort upload in state %s", s.state)
+	}
+
+	return s, fm.terminateLocked(key, s, StateAborted, ErrUploadAborted, retention(s.info)), nil
+}
+
+// expire moves receiving sessions that have not received a chunk since cutoff
+// to StateExpired and returns them with their chunk paths for cleanup.
+func (fm *FileManager) expire(cutoff time.Time) map[*uploadSession][]string {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	expired := make(map[*uploadSession][]string)
+	for key, s := range fm.sessions {
+		if s.state == StateReceiving && s.updatedAt.Before(cutoff) {
+			expired[s] = fm.terminateLocked(key, s, StateExpired, ErrUploadExpired, retention(s.info))
+		}
+	}
+	return expired
+}
+
+// terminateLocked ends a receiving session in the given state, wakes callers
+// waiting on it and returns its chunk paths. The caller must hold fm.mutex
+// for writing.
+func (fm *FileManager) terminateLocked(key string, s *uploadSession, state SessionState, err error, retain time.Duration) []string {
+	paths := make([]string, len(s.chunks))
+	for i, chunk := range s.chunks {
+		paths[i] = chunk.path
+	}
+
+	s.state = state
+	s.err = err
+	close(s.done)
+	fm.forgetAfterLocked(key, s, retain)
+	return paths
+}
+
+// stagedBytes sums the sizes of the chunks stored for the session under key,
+// leaving out chunk index exclude.
+func (fm *FileManager) stagedBytes(key string, exclude int) int64 {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	s, exists := fm.sessions[key]
+	if !exists {
+		return 0
+	}
+	var total int64
+	for i, chunk := range s.chunks {
+		if i != exclude {
+			total += chunk.size
+		}
+	}
+	return total
+}
+
+// contentType returns the media type detected from the first chunk of the
+// session stored under key.
+func (fm *FileManager) contentType(key string) string {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	s, exists := fm.sessions[key]
+	if !exists || len(s.chunks) == 0 {
+		return ""
 	}
-	fm.chunks[fileName][chunkIndex] = chunkPath
+	return s.chunks[0].contentType
+}
+
+// owner returns the owner recorded for the session stored under key.
+func (fm *FileManager) owner(key string) (string, bool) {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	s, exists := fm.sessions[key]
+	if !exists {
+		return "", false
+	}
+	return s.info.Owner, true
+}
+
+// settling returns the session for fileName if it is assembling or finished,
+// and nil if it is still receiving chunks or does not exist.
+func (fm *FileManager) settling(fileName string) *uploadSession {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	s, exists := fm.sessions[fileName]
+	if !exists || s.state == StateReceiving {
+		return nil
+	}
+	return s
+}
+
+// setAssemblyCancel registers the function that cancels the assembly of s.
+// If cancellation was already requested, it is called right away.
+func (fm *FileManager) setAssemblyCancel(s *uploadSession, cancel context.CancelFunc) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	s.cancelAssembly = cancel
+	if s.cancelRequested {
+		cancel()
+	}
+}
+
+// cancelAssembly requests cancellation of the session stored under key,
+// which must be assembling.
+func (fm *FileManager) cancelAssembly(key string) error {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	s, exists := fm.sessions[key]
+	if !exists {
+		return ErrUploadNotFound
+	}
+	if s.state != StateAssembling {
+		return fmt.Errorf("cannot cancel assembly of upload in state %s", s.state)
+	}
+
+	s.cancelRequested = true
+	if s.cancelAssembly != nil {
+		s.cancelAssembly()
+	}
+	return nil
+}
+
+// rollbackAssembly returns an assembling session to StateReceiving with its
+// chunks intact, so that it can be assembled again later.
+func (fm *FileManager) rollbackAssembly(s *uploadSession) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	if s.state != StateAssembling {
+		return
+	}
+	s.state = StateReceiving
+	s.assembledChunks = 0
+	s.assembledBytes = 0
+	s.cancelAssembly = nil
+	s.cancelRequested = false
+}
+
+// recordAssembly stores how far the assembly of s has progressed.
+func (fm *FileManager) recordAssembly(s *uploadSession, chunksDone int, bytesWritten int64) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	s.assembledChunks = chunksDone
+	s.assembledBytes = bytesWritten
+}
+
+// usage counts the sessions still receiving or assembling and the bytes their
+// chunks take up in the temp directory.
+func (fm *FileManager) usage() (sessions int, tempBytes int64) {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	for _, s := range fm.sessions {
+		if s.state != StateReceiving && s.state != StateAssembling {
+			continue
+		}
+		sessions++
+		for _, chunk := range s.chunks {
+			tempBytes += chunk.size
+		}
+	}
+	return sessions, tempBytes
+}
+
+// progress returns a snapshot of the session stored under key.
+func (fm *FileManager) progress(key string) (Progress, bool) {
+	fm.mutex.RLock()
+	s, exists := fm.sessions[key]
+	fm.mutex.RUnlock()
+
+	if !exists {
+		return Progress{}, false
+	}
+	return fm.snapshot(s), true
+}
+
+// snapshot describes the current state of s.
+func (fm *FileManager) snapshot(s *uploadSession) Progress {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	p := Progress{
+		UploadID:        s.info.UploadID,
+		FileName:        s.info.FileName,
+		State:           s.state,
+		TotalChunks:     len(s.chunks),
+		FileSize:        s.info.FileSize,
+		AssembledChunks: s.assembledChunks,
+		AssembledBytes:  s.assembledBytes,
+		Metadata:        s.result,
+	}
+	for _, chunk := range s.chunks {
+		if chunk.path != "" {
+			p.ReceivedChunks++
+			p.ReceivedBytes += chunk.size
+		}
+	}
+	if s.err != nil {
+		p.Error = s.err.Error()
+	}
+	return p
+}
+
+// wait blocks until the session has finished or been aborted and returns its
+// outcome, or until ctx is done.
+func (s *uploadSession) wait(ctx context.Context) (map[string]interface{}, error) {
+	select {
+	case <-s.done:
+		return s.result, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// State returns the state of the session for a given file.
+// It returns false if the file manager has no session for it.
+func (fm *FileManager) State(fileName string) (SessionState, bool) {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	s, exists := fm.sessions[fileName]
+	if !exists {
+		return "", false
+	}
+	return s.state, true
 }
 
 // IsComplete checks if all chunks for a given file are present.
@@ -144,13 +564,13 @@ func (fm *FileManager) IsComplete(fileName string) bool {
 	fm.mutex.RLock()
 	defer fm.mutex.RUnlock()
 
-	chunks, exists := fm.chunks[fileName]
+	s, exists := fm.sessions[fileName]
 	if !exists {
 		return false
 	}
 
-	for _, chunk := range chunks {
-		if chunk == "" {
+	for _, chunk := range s.chunks {
+		if chunk.path == "" {
 			return false
 		}
 	}
@@ -158,32 +578,259 @@ func (fm *FileManager) IsComplete(fileName string) bool {
 }
 
 // GetChunks retrieves the list of chunk paths for a given file.
-// It returns a slice of strings containing the paths of the chunks.
+// It returns a copy of the paths, or nil if the file is unknown.
 func (fm *FileManager) GetChunks(fileName string) []string {
 	fm.mutex.RLock()
 	defer fm.mutex.RUnlock()
-	return fm.chunks[fileName]
+
+	s, exists := fm.sessions[fileName]
+	if !exists {
+		return nil
+	}
+
+	paths := make([]string, len(s.chunks))
+	for i, chunk := range s.chunks {
+		paths[i] = chunk.path
+	}
+	return paths
 }
 
 // RemoveFile removes all chunks associated with a file from the file manager.
-// It deletes the entry from the chunks map.
+// It deletes the entry from the sessions map.
 func (fm *FileManager) RemoveFile(fileName string) {
 	fm.mutex.Lock()
 	defer fm.mutex.Unlock()
-	delete(fm.chunks, fileName)
+	delete(fm.sessions, fileName)
+}
+
+// maxFormOverhead is how much a request body may exceed a token's size
+// limit or MaxMemory, for the multipart framing and the other form fields.
+const maxFormOverhead = 1 << 20
+
+// finishedSessionRetention is how long the outcome of an upload with an
+// uploadId is remembered for retried chunks.
+const finishedSessionRetention = 10 * time.Minute
+
+// retention is how long a finished session is remembered. Sessions keyed by
+// file name are forgotten at once, so the name can be uploaded again.
+func retention(info SessionInfo) time.Duration {
+	if info.UploadID == "" {
+		return 0
+	}
+	return finishedSessionRetention
+}
+
+// ErrChunkConflict is returned when a chunk index is uploaded again with
+// different content and the conflict policy does not allow overwriting.
+var ErrChunkConflict = errors.New("chunk conflicts with previously uploaded content")
+
+// ErrUploadNotFound is returned when aborting an upload the uploader does not know.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrUploadAborted is returned for chunks that arrive after their upload was aborted.
+var ErrUploadAborted = errors.New("upload was aborted")
+
+// ErrUploadExpired is returned for chunks that arrive after their upload expired.
+var ErrUploadExpired = errors.New("upload expired")
+
+// ErrUploadTooLarge is returned for chunks that would make an upload larger
+// than its declared file size, or requests larger than their token allows.
+var ErrUploadTooLarge = errors.New("upload too large")
+
+// ErrShuttingDown is returned for requests that arrive after Shutdown was called.
+var ErrShuttingDown = errors.New("uploader is shutting down")
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// errSessionSettled reports a chunk that arrived after its session stopped
+// receiving chunks.
+var errSessionSettled = errors.New("upload session is no longer receiving chunks")
+
+// errDuplicateChunk reports a chunk identical to the one already stored.
+var errDuplicateChunk = errors.New("chunk already received")
+
+// NewUploader creates an Uploader, filling unset configuration with defaults.
+func NewUploader(config Config) *Uploader {
+	return newUploader(config, NewFileManager())
+}
+
+func newUploader(config Config, files *FileManager) *Uploader {
+	if config.TempDir == "" {
+		config.TempDir = "./temp_chunks"
+	}
+	if config.UploadDir == "" {
+		config.UploadDir = "./uploads"
+	}
+	if config.MaxMemory <= 0 {
+		config.MaxMemory = 32 << 20
+	}
+	if config.MaxRequestBytes <= 0 {
+		config.MaxRequestBytes = config.MaxMemory + maxFormOverhead
+	}
+	if config.QuarantineDir == "" {
+		config.QuarantineDir = "./quarantine"
+	}
+	if config.Logger == nil {
+		config.Logger = slog.New(discardHandler{})
+	}
+	if config.TracerProvider == nil {
+		config.TracerProvider = otel.GetTracerProvider()
+	}
+	if config.Propagator == nil {
+		config.Propagator = propagation.TraceContext{}
+	}
+	u := &Uploader{config: config, files: files, progress: NewProgressBroker(), tracer: config.TracerProvider.Tracer(tracerName)}
+	if config.RateLimit.enabled() {
+		u.limiter = newRateLimiter(config.RateLimit)
+	}
+	if config.Quota.enabled() {
+		u.quota = newQuotaTracker(config.Quota)
+	}
+	u.stopCtx, u.stop = context.WithCancel(context.Background())
+	config.Metrics.register(files)
+
+	if config.StateFile != "" {
+		if err := files.loadState(config.StateFile); err != nil {
+			config.Logger.Warn("failed to restore upload sessions", "path", config.StateFile, "error", err)
+		}
+	}
+	return u
 }
 
 var fileManager = NewFileManager()
 
+var defaultUploader = newUploader(Config{}, fileManager)
+
 // uploaderUtility handles the file upload request.
 // It processes multipart form data, saves file chunks, and stitches them together if all chunks are received.
 func UploaderHelper(r *http.Request) (map[string]interface{}, error) {
+	return defaultUploader.Handle(r)
+}
+
+// AbortUpload cancels an in-progress upload handled by UploaderHelper.
+func AbortUpload(ctx context.Context, uploadID string) error {
+	return defaultUploader.Abort(ctx, uploadID)
+}
+
+// Abort cancels an in-progress upload. It deletes the staged chunks and
+// rejects any chunk that still arrives for the upload. Uploads sent without
+// an uploadId are identified by their file name.
+func (u *Uploader) Abort(ctx context.Context, uploadID string) error {
+	s, chunks, err := u.files.abort(uploadID)
+	if err != nil {
+		return err
+	}
+	u.removeChunkFiles(s.info, chunks)
+	u.quota.finish(uploadID, 0)
+	u.publishProgress(s)
+	u.config.Metrics.recordUpload(EventAborted)
+	u.sessionLogger(s.info).Info("upload aborted")
+
+	u.hookAbort(ctx, s.info)
+	u.emit(ctx, newEvent(EventAborted, s.info))
+	return nil
+}
+
+// ExpireSessions drops uploads that have not received a chunk within
+// Config.SessionTTL, deletes their chunks and emits an expired event for
+// each. It returns the number of expired uploads and does nothing when no
+// TTL is configured. Applications call it periodically.
+func (u *Uploader) ExpireSessions(ctx context.Context) int {
+	if u.config.SessionTTL <= 0 {
+		return 0
+	}
+
+	expired := u.files.expire(time.Now().Add(-u.config.SessionTTL))
+	for s, chunks := range expired {
+		u.removeChunkFiles(s.info, chunks)
+		u.quota.finish(s.info.key(), 0)
+		u.publishProgress(s)
+		u.config.Metrics.recordUpload(EventExpired)
+		u.sessionLogger(s.info).Info("upload expired")
+		u.emit(ctx, newEvent(EventExpired, s.info))
+	}
+	return len(expired)
+}
+
+// CancelAssembly stops an upload that is being assembled. The partially
+// written file is removed and the upload fails with a cancellation error.
+func (u *Uploader) CancelAssembly(uploadID string) error {
+	return u.files.cancelAssembly(uploadID)
+}
+
+// Handle processes a single chunk upload request with the uploader's configuration.
+// It saves the chunk and stitches the file together once all chunks are received.
+// A DELETE request aborts the upload named by its uploadId (or fileName) parameter.
+func (u *Uploader) Handle(r *http.Request) (map[string]interface{}, error) {
+	if r.Method == http.MethodDelete {
+		return u.handleAbort(r)
+	}
 	if r.Method != http.MethodPost {
-		return nil, fmt.Errorf("method not allowed")
+		return nil, errMethodNotAllowed
+	}
+
+	if !u.beginOperation() {
+		return nil, ErrShuttingDown
+	}
+	defer u.inflight.Done()
+
+	ctx, span := u.startRequestSpan(r)
+	result, err := u.handleChunk(ctx, r)
+	endSpan(span, err)
+	return result, err
+}
+
+// handleChunk stores one chunk from a POST request and assembles the file
+// once the last chunk is in.
+func (u *Uploader) handleChunk(ctx context.Context, r *http.Request) (map[string]interface{}, error) {
+	// Receiving and persisting the chunk stops when the client goes away, the
+	// chunk write timeout passes or a shutdown deadline is reached; the body
+	// is read through the context so a stalled upload does not keep parsing.
+	ctx, cancel := withTimeout(ctx, u.config.Timeouts.ChunkWrite)
+	defer cancel()
+	defer context.AfterFunc(u.stopCtx, cancel)()
+
+	// Credentials sent outside the body are checked before accepting any data,
+	// and bound the body to the token's size limit. Bodies carrying their own
+	// token are bounded by MaxRequestBytes until it is checked
+	var claims UploadClaims
+	authorized := false
+	if len(u.config.TokenSecret) > 0 && hasCredentialsOutsideBody(r) {
+		var err error
+		if claims, err = u.authorize(r); err != nil {
+			return nil, err
+		}
+		authorized = true
+	}
+
+	// So are the client's rate limits; the body is throttled while it is read
+	body := io.Reader(r.Body)
+	if u.limiter != nil {
+		release, bytes, err := u.limiter.acquire(u.limiter.config.Key(r, claims.Owner))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		if bytes != nil {
+			body = throttledReader{ctx: ctx, r: body, bucket: bytes}
+		}
+	}
+	r.Body = contextReadCloser{contextReader{ctx: ctx, r: body}, r.Body}
+	if authorized && claims.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, claims.MaxSize+maxFormOverhead)
+	} else if len(u.config.TokenSecret) > 0 && !authorized {
+		r.Body = http.MaxBytesReader(nil, r.Body, u.config.MaxRequestBytes)
 	}
 
 	// Parse multipart form
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	_, parseSpan := u.tracer.Start(ctx, "chunkeduploader.ParseForm")
+	err := r.ParseMultipartForm(u.config.MaxMemory)
+	endSpan(parseSpan, err)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("%w: request body exceeds %d bytes", ErrUploadTooLarge, tooLarge.Limit)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("error parsing form: %v", err)
 	}
 
@@ -193,7 +840,7 @@ func UploaderHelper(r *http.Request) (map[string]interface{}, error) {
 	totalChunksStr := r.FormValue("totalChunks")
 	fileSizeStr := r.FormValue("fileSize")
 	additionalParamsStr := r.FormValue("additionalParams")
-	additionalParams := parseAdditionalParams(additionalParamsStr)
+	additionalParams := u.parseAdditionalParams(additionalParamsStr)
 
 	if fileName == "" {
 		return nil, fmt.Errorf("fileName is required")
@@ -214,58 +861,401 @@ func UploaderHelper(r *http.Request) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("invalid fileSize")
 	}
 
+	if totalChunks <= 0 || chunkIndex < 0 || chunkIndex >= totalChunks {
+		return nil, fmt.Errorf("chunkIndex out of range")
+	}
+
+	// Uploads that carry an uploadId are tracked by it and remember their
+	// outcome for a while, so a retried final chunk gets the same answer.
+	uploadID := r.FormValue("uploadId")
+	sessionKey := fileName
+	if uploadID != "" {
+		if !validUploadID(uploadID) {
+			return nil, fmt.Errorf("invalid uploadId")
+		}
+		sessionKey = uploadID
+	}
+
+	info := SessionInfo{
+		UploadID:         uploadID,
+		FileName:         fileName,
+		FileSize:         fileSize,
+		TotalChunks:      totalChunks,
+		AdditionalParams: additionalParams,
+	}
+
+	// With tokens enabled every chunk must carry one that allows this upload
+	if !authorized {
+		if claims, err = u.authorize(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkClaims(claims, info); err != nil {
+		return nil, err
+	}
+	info.Owner = claims.Owner
+	info.Prefix = claims.Prefix
+	if info.Owner == "" && u.config.Quota.OwnerParam != "" {
+		if owner, ok := additionalParams[u.config.Quota.OwnerParam]; ok && owner != nil {
+			info.Owner = fmt.Sprint(owner)
+		}
+	}
+	if owner, exists := u.files.owner(sessionKey); exists && owner != info.Owner {
+		return nil, fmt.Errorf("%w: upload belongs to another owner", ErrInvalidToken)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(append(sessionAttributes(info), attrChunkIndex.Int(chunkIndex))...)
+	fail := func(err error) (map[string]interface{}, error) {
+		// A reservation made for a session that never started is returned
+		if _, exists := u.files.State(sessionKey); !exists {
+			u.quota.finish(sessionKey, 0)
+		}
+		u.sessionLogger(info).Warn("chunk rejected", "chunkIndex", chunkIndex, "error", err)
+		u.hookError(ctx, info, err)
+		return nil, err
+	}
+
+	// A chunk for a session that is already assembling or finished is a
+	// retry; it must not touch the chunk files and gets the shared outcome.
+	if s := u.files.settling(sessionKey); s != nil {
+		metadata, err := s.wait(ctx)
+		return completionResult(fileName, uploadID, metadata, err, additionalParams)
+	}
+
 	// Get the uploaded file
-	file, _, err := r.FormFile("chunk")
+	file, header, err := r.FormFile("chunk")
 	if err != nil {
 		return nil, fmt.Errorf("error getting file: %v", err)
 	}
 	defer file.Close()
 
-	// Create temp directory for chunks
-	tempDir := "./temp_chunks"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return nil, fmt.Errorf("error creating temp directory: %v", err)
+	// Whatever the quota, the chunks of a session cannot add up to more than
+	// its declared size
+	if u.files.stagedBytes(sessionKey, chunkIndex)+header.Size > fileSize {
+		return fail(fmt.Errorf("%w: chunks exceed the declared file size of %d bytes", ErrUploadTooLarge, fileSize))
 	}
 
-	// Save chunk to temporary file
-	chunkPath := filepath.Join(tempDir, fmt.Sprintf("%s_chunk_%d", fileName, chunkIndex))
-	tempFile, err := os.Create(chunkPath)
+	// A new session reserves its declared size, which its chunks fit in as
+	// checked above
+	err = u.quota.checkFreeSpace(u.config.TempDir, u.config.UploadDir)
+	if err == nil {
+		err = u.quota.reserve(sessionKey, info.Owner, fileSize)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error creating temp file: %v", err)
+		return fail(err)
 	}
-	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
-		return nil, fmt.Errorf("error saving chunk: %v", err)
+	// Create temp directory for chunks
+	tempDir := u.config.TempDir
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fail(fmt.Errorf("error creating temp directory: %v", err))
 	}
 
-	// Add chunk to file manager
-	fileManager.AddChunk(fileName, chunkPath, chunkIndex, totalChunks)
+	// Save chunk to a private staging file first, so concurrent uploads of the
+	// same index never write into a file another request may be reading.
+	chunkPath := filepath.Join(tempDir, fmt.Sprintf("%s_chunk_%d", sessionKey, chunkIndex))
+	started := time.Now()
+	staged, err := u.stageChunk(ctx, file, chunkPath)
+	if err != nil {
+		return fail(err)
+	}
+	stagedPath := staged.path
 
-	// Check if all chunks are received
-	if fileManager.IsComplete(fileName) {
-		metadata, err := stitchFile(fileName, fileSize)
+	// The first chunk's magic bytes tell what the file really is, so a
+	// refused type is rejected before the rest is uploaded
+	if chunkIndex == 0 {
+		if staged.contentType, err = sniffFile(stagedPath); err == nil {
+			err = u.config.TypePolicy.check(fileName, staged.contentType)
+		}
+		if err == nil {
+			err = checkDetectedType(claims, fileName, staged.contentType)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error stitching file: %v", err)
+			os.Remove(stagedPath)
+			return fail(err)
 		}
+	}
 
-		// Clean up chunks
-		cleanupChunks(fileName)
+	incoming := incomingChunk{
+		session:    info,
+		chunkIndex: chunkIndex,
+		chunkPath:  chunkPath,
+		staged:     staged,
+	}
 
-		return map[string]interface{}{
-			"status":           "complete",
-			"fileName":         fileName,
-			"message":          "File uploaded and stitched successfully",
-			"metadata":         metadata,
-			"additionalParams": additionalParams,
-		}, nil
+	chunk := ChunkInfo{
+		FileName:    fileName,
+		ChunkIndex:  chunkIndex,
+		TotalChunks: totalChunks,
+		FileSize:    fileSize,
+		Size:        incoming.staged.size,
+		Digest:      incoming.staged.digest,
+		ContentType: incoming.staged.contentType,
+	}
+	if err := u.hookChunkReceived(ctx, info, chunk); err != nil {
+		os.Remove(stagedPath)
+		return fail(fmt.Errorf("chunk rejected: %w", err))
+	}
+
+	// A request cancelled while its chunk was being checked must not leave
+	// the chunk behind.
+	if err := ctx.Err(); err != nil {
+		os.Remove(stagedPath)
+		return fail(fmt.Errorf("error saving chunk: %w", err))
+	}
+
+	// Add chunk to file manager; only one request gets to assemble the file
+	session, assemble, err := u.files.commitChunk(sessionKey, incoming, u.config.ConflictPolicy)
+	if errors.Is(err, errSessionSettled) {
+		metadata, err := session.wait(ctx)
+		return completionResult(fileName, uploadID, metadata, err, additionalParams)
+	}
+	duplicate := errors.Is(err, errDuplicateChunk)
+	if err != nil && !duplicate {
+		return fail(err)
+	}
+	if !duplicate {
+		u.quota.setStaged(sessionKey, u.files.stagedBytes(sessionKey, -1))
+		took := time.Since(started)
+		u.sessionLogger(info).Debug("chunk received", "chunkIndex", chunkIndex, "bytes", incoming.staged.size, "duration", took)
+		u.config.Metrics.recordChunk(incoming.staged.size, took)
+		u.publishProgress(session)
 	}
 
-	return map[string]interface{}{
+	if assemble {
+		metadata, err := u.assemble(ctx, sessionKey, session, info, retention(info))
+		return completionResult(fileName, uploadID, metadata, err, additionalParams)
+	}
+
+	result := map[string]interface{}{
 		"status":           "chunk_received",
 		"fileName":         fileName,
 		"chunkIndex":       chunkIndex,
 		"totalChunks":      totalChunks,
 		"additionalParams": additionalParams,
-	}, nil
+	}
+	if duplicate {
+		result["duplicate"] = true
+	}
+	if uploadID != "" {
+		result["uploadId"] = uploadID
+	}
+	return result, nil
+}
+
+// stageChunk writes src to a new staging file next to chunkPath and returns
+// its record. The staging file is removed if writing fails.
+func (u *Uploader) stageChunk(ctx context.Context, src io.Reader, chunkPath string) (staged chunkRecord, err error) {
+	ctx, span := u.tracer.Start(ctx, "chunkeduploader.PersistChunk")
+	defer func() {
+		span.SetAttributes(attrChunkBytes.Int64(staged.size))
+		endSpan(span, err)
+	}()
+
+	stagedPath := chunkPath + "." + uuid.New().String() + ".part"
+	tempFile, err := os.Create(stagedPath)
+	if err != nil {
+		return chunkRecord{}, fmt.Errorf("error creating temp file: %v", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), contextReader{ctx: ctx, r: src})
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(stagedPath)
+		return chunkRecord{}, fmt.Errorf("error saving chunk: %w", err)
+	}
+	return chunkRecord{path: stagedPath, size: size, digest: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// assemble stitches a session's chunks into the final file, lets the OnComplete
+// hook accept or veto it, and records the outcome on the session.
+func (u *Uploader) assemble(ctx context.Context, key string, s *uploadSession, info SessionInfo, retain time.Duration) (map[string]interface{}, error) {
+	// Assembly outlives the request that triggered it, but can be cancelled
+	// through CancelAssembly and is bounded by the assembly timeout.
+	assemblyCtx, cancelTimeout := withTimeout(context.WithoutCancel(ctx), u.config.Timeouts.Assembly)
+	defer cancelTimeout()
+	assemblyCtx, cancel := context.WithCancel(assemblyCtx)
+	defer cancel()
+	defer context.AfterFunc(u.stopCtx, cancel)()
+	u.files.setAssemblyCancel(s, cancel)
+
+	report := func(chunksDone int, bytesWritten int64) {
+		u.files.recordAssembly(s, chunksDone, bytesWritten)
+		p := u.files.snapshot(s)
+		if u.config.OnAssemblyProgress != nil {
+			u.config.OnAssemblyProgress(p)
+		}
+		u.progress.Publish(p.key(), p)
+	}
+
+	chunks := u.files.GetChunks(key)
+	syncSteps, backgroundSteps := splitSteps(u.config.Processors)
+	started := time.Now()
+	stitchCtx, stitchSpan := u.tracer.Start(assemblyCtx, "chunkeduploader.StitchFile", trace.WithAttributes(sessionAttributes(info)...))
+	metadata, err := u.stitchFile(stitchCtx, info.Prefix, info.FileName, chunks, info.FileSize, report)
+	if err == nil {
+		stitchSpan.SetAttributes(attrStitchBytes.Int64(metadata["fileSize"].(int64)))
+	}
+	endSpan(stitchSpan, err)
+	took := time.Since(started)
+	u.config.Metrics.recordStitch(took)
+	if err != nil {
+		err = fmt.Errorf("error stitching file: %w", err)
+	} else {
+		if info.Owner != "" {
+			metadata["owner"] = info.Owner
+		}
+		if detected := u.files.contentType(key); detected != "" {
+			metadata["detectedMimeType"] = detected
+		}
+		if scanErr := u.scan(assemblyCtx, metadata); scanErr != nil {
+			metadata = nil
+			err = scanErr
+		} else if publishErr := publishFile(metadata); publishErr != nil {
+			metadata = nil
+			err = publishErr
+		} else if derived, processErr := u.processFile(assemblyCtx, info, metadata, nil, syncSteps); processErr != nil {
+			os.Remove(metadata["path"].(string))
+			removeDerivedFiles(derived)
+			metadata = nil
+			err = processErr
+		} else if storeErr := u.storeContent(metadata); storeErr != nil {
+			os.Remove(metadata["path"].(string))
+			removeDerivedFiles(derived)
+			metadata = nil
+			err = storeErr
+		} else if hookErr := u.hookComplete(assemblyCtx, info, metadata); hookErr != nil {
+			u.discardStored(metadata)
+			removeDerivedFiles(derived)
+			metadata = nil
+			err = fmt.Errorf("finalization rejected: %w", hookErr)
+		}
+	}
+
+	// An assembly interrupted by shutdown keeps its chunks, so the upload
+	// can be completed after a restart.
+	if err != nil && u.stopCtx.Err() != nil {
+		u.files.rollbackAssembly(s)
+		u.publishProgress(s)
+		u.sessionLogger(info).Info("assembly interrupted by shutdown", "duration", took)
+		return nil, ErrShuttingDown
+	}
+
+	logger := u.sessionLogger(info)
+	if err != nil {
+		logger.Error("upload failed", "duration", took, "error", err)
+	} else {
+		logger.Info("stitched file", "storedName", metadata["storedName"], "bytes", metadata["fileSize"], "duration", took)
+	}
+
+	// Clean up chunks
+	u.removeChunkFiles(info, chunks)
+	u.files.finishAssembly(key, s, metadata, err, retain)
+	var stored int64
+	if err == nil {
+		stored = metadata["fileSize"].(int64)
+	}
+	u.quota.finish(key, stored)
+	u.publishProgress(s)
+
+	event := newEvent(EventCompleted, info)
+	event.File = fileMetadataFrom(metadata)
+	event.Metadata = metadata
+	if err != nil {
+		u.hookError(assemblyCtx, info, err)
+		event.Type = EventFailed
+		event.Error = err.Error()
+
+		var scanErr *ScanError
+		if errors.As(err, &scanErr) {
+			event.Metadata = map[string]interface{}{
+				"scanVerdict":    string(scanErr.Result.Verdict),
+				"signature":      scanErr.Result.Signature,
+				"quarantinePath": scanErr.QuarantinePath,
+			}
+		}
+	}
+	u.config.Metrics.recordUpload(event.Type)
+	u.emit(ctx, event)
+
+	if err == nil && len(backgroundSteps) > 0 {
+		u.processInBackground(info, metadata, backgroundSteps)
+	}
+	return metadata, err
+}
+
+// handleAbort aborts the upload identified by the request's uploadId, or by
+// its fileName for uploads sent without one.
+func (u *Uploader) handleAbort(r *http.Request) (map[string]interface{}, error) {
+	uploadID := r.FormValue("uploadId")
+	fileName := r.FormValue("fileName")
+	key := uploadID
+	if key == "" {
+		key = fileName
+	}
+	if key == "" {
+		return nil, fmt.Errorf("uploadId is required")
+	}
+
+	claims, err := u.authorize(r)
+	if err != nil {
+		return nil, err
+	}
+	if claims.UploadID != "" && claims.UploadID != uploadID {
+		return nil, fmt.Errorf("%w: token is for another upload", ErrInvalidToken)
+	}
+	if owner, exists := u.files.owner(key); exists && owner != claims.Owner {
+		return nil, fmt.Errorf("%w: upload belongs to another owner", ErrInvalidToken)
+	}
+
+	if err := u.Abort(r.Context(), key); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"status":  "aborted",
+		"message": "Upload aborted",
+	}
+	if uploadID != "" {
+		result["uploadId"] = uploadID
+	} else {
+		result["fileName"] = fileName
+	}
+	return result, nil
+}
+
+// completionResult builds the response for a session that has finished
+// assembling, or returns its assembly error.
+func completionResult(fileName, uploadID string, metadata map[string]interface{}, err error, additionalParams map[string]interface{}) (map[string]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"status":           "complete",
+		"fileName":         fileName,
+		"message":          "File uploaded and stitched successfully",
+		"metadata":         metadata,
+		"additionalParams": additionalParams,
+	}
+	if uploadID != "" {
+		result["uploadId"] = uploadID
+	}
+	return result, nil
+}
+
+// validUploadID reports whether id is safe to use as a session key and in
+// chunk file names.
+func validUploadID(id string) bool {
+	if len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,23 @@
+package chunkeduploader
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every record. It is the default Config.Logger handler,
+// so the package is silent unless a logger is configured.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// sessionLogger returns the configured logger annotated with the upload.
+func (u *Uploader) sessionLogger(info SessionInfo) *slog.Logger {
+	if info.UploadID == "" {
+		return u.config.Logger.With("fileName", info.FileName)
+	}
+	return u.config.Logger.With("uploadId", info.UploadID, "fileName", info.FileName)
+}
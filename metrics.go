@@ -0,0 +1,196 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics collects upload statistics and exposes them in the Prometheus text
+// format. Set it as Config.Metrics and serve it on a metrics endpoint; one
+// Metrics may be shared by several uploaders.
+type Metrics struct {
+	mutex          sync.Mutex
+	chunksReceived uint64
+	bytesReceived  uint64
+	uploads        map[string]uint64 // outcome -> count
+	chunkSize      *histogram
+	chunkWrite     *histogram
+	stitchDuration *histogram
+	files          []*FileManager // sources for the session gauges
+}
+
+// Histogram buckets, in bytes and seconds.
+var (
+	chunkSizeBuckets      = []float64{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20}
+	chunkWriteBuckets     = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	stitchDurationBuckets = []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		uploads:        make(map[string]uint64),
+		chunkSize:      newHistogram(chunkSizeBuckets),
+		chunkWrite:     newHistogram(chunkWriteBuckets),
+		stitchDuration: newHistogram(stitchDurationBuckets),
+	}
+}
+
+// histogram is a cumulative Prometheus histogram. Callers hold Metrics.mutex.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+// The record methods are no-ops on a nil *Metrics, so the uploader can call
+// them whether or not metrics are configured.
+
+func (m *Metrics) register(files *FileManager) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files = append(m.files, files)
+}
+
+func (m *Metrics) recordChunk(size int64, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.chunksReceived++
+	m.bytesReceived += uint64(size)
+	m.chunkSize.observe(float64(size))
+	m.chunkWrite.observe(took.Seconds())
+}
+
+func (m *Metrics) recordStitch(took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stitchDuration.observe(took.Seconds())
+}
+
+func (m *Metrics) recordUpload(outcome EventType) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploads[outcomeLabel(outcome)]++
+}
+
+// outcomeLabel turns "upload.completed" into "completed".
+func outcomeLabel(t EventType) string {
+	s := string(t)
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return s[i+1:]
+		}
+	}
+	return s
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var sessions int
+	var tempBytes int64
+	m.mutex.Lock()
+	files := append([]*FileManager(nil), m.files...)
+	m.mutex.Unlock()
+	for _, fm := range files {
+		n, bytes := fm.usage()
+		sessions += n
+		tempBytes += bytes
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	writeMetric(cw, "chunkeduploader_chunks_received_total", "counter", "Chunks received and stored.")
+	fmt.Fprintf(cw, "chunkeduploader_chunks_received_total %d\n", m.chunksReceived)
+	writeMetric(cw, "chunkeduploader_bytes_received_total", "counter", "Bytes of chunk data received and stored.")
+	fmt.Fprintf(cw, "chunkeduploader_bytes_received_total %d\n", m.bytesReceived)
+
+	writeMetric(cw, "chunkeduploader_uploads_total", "counter", "Uploads finished, by outcome.")
+	for _, outcome := range []string{"completed", "failed", "aborted", "expired"} {
+		fmt.Fprintf(cw, "chunkeduploader_uploads_total{outcome=%q} %d\n", outcome, m.uploads[outcome])
+	}
+
+	writeHistogram(cw, "chunkeduploader_chunk_size_bytes", "Size of received chunks.", m.chunkSize)
+	writeHistogram(cw, "chunkeduploader_chunk_write_seconds", "Time to receive and store a chunk.", m.chunkWrite)
+	writeHistogram(cw, "chunkeduploader_stitch_duration_seconds", "Time to stitch chunks into the final file.", m.stitchDuration)
+
+	writeMetric(cw, "chunkeduploader_active_sessions", "gauge", "Uploads receiving chunks or assembling.")
+	fmt.Fprintf(cw, "chunkeduploader_active_sessions %d\n", sessions)
+	writeMetric(cw, "chunkeduploader_temp_bytes", "gauge", "Bytes of chunk data held in the temp directory.")
+	fmt.Fprintf(cw, "chunkeduploader_temp_bytes %d\n", tempBytes)
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func writeMetric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	writeMetric(w, name, "histogram", help)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// countingWriter tracks bytes written and the first error for WriteTo.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
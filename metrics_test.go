@@ -0,0 +1,69 @@
+package chunkeduploader
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_PrometheusExposition(t *testing.T) {
+	dir := t.TempDir()
+	metrics := NewMetrics()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Metrics:   metrics,
+	})
+
+	upload := func(uploadID string, index int, total int, data string) {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    uploadID,
+			"fileName":    uploadID + ".txt",
+			"chunkIndex":  fmt.Sprintf("%d", index),
+			"totalChunks": fmt.Sprintf("%d", total),
+			"fileSize":    "13",
+		}, []byte(data))
+		if _, err := u.Handle(req); err != nil {
+			t.Fatalf("Upload of %s chunk %d failed: %v", uploadID, index, err)
+		}
+	}
+
+	upload("done", 0, 2, "Hello, ")
+	upload("done", 1, 2, "World!")
+	upload("pending", 0, 2, "Hello, ")
+	upload("aborted", 0, 2, "Hello, ")
+	if err := u.Abort(context.Background(), "aborted"); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus content type, got %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE chunkeduploader_chunks_received_total counter",
+		"chunkeduploader_chunks_received_total 4",
+		"chunkeduploader_bytes_received_total 27",
+		`chunkeduploader_uploads_total{outcome="completed"} 1`,
+		`chunkeduploader_uploads_total{outcome="aborted"} 1`,
+		`chunkeduploader_uploads_total{outcome="failed"} 0`,
+		"# TYPE chunkeduploader_chunk_size_bytes histogram",
+		`chunkeduploader_chunk_size_bytes_bucket{le="65536"} 4`,
+		`chunkeduploader_chunk_size_bytes_bucket{le="+Inf"} 4`,
+		"chunkeduploader_chunk_size_bytes_sum 27",
+		"chunkeduploader_chunk_write_seconds_count 4",
+		"chunkeduploader_stitch_duration_seconds_count 1",
+		"chunkeduploader_active_sessions 1",
+		"chunkeduploader_temp_bytes 7",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in metrics output:\n%s", line, body)
+		}
+	}
+}
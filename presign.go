@@ -0,0 +1,115 @@
+package chunkeduploader
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of a pre-signed upload URL.
+const (
+	presignUploadID     = "uploadId"
+	presignFileName     = "fileName"
+	presignMaxSize      = "maxSize"
+	presignContentTypes = "contentTypes"
+	presignPrefix       = "prefix"
+	presignOwner        = "owner"
+	presignExpires      = "expires"
+	presignSignature    = "signature"
+)
+
+// PresignUploadURL returns rawURL with query parameters that let a client
+// send the chunks of claims.UploadID straight to the chunk handler until
+// claims.ExpiresAt, within the limits of claims. The parameters are signed
+// with secret, which must be the uploader's Config.TokenSecret.
+func PresignUploadURL(secret []byte, rawURL string, claims UploadClaims) (string, error) {
+	if claims.UploadID == "" || !validUploadID(claims.UploadID) {
+		return "", fmt.Errorf("pre-signed URLs need a valid uploadId")
+	}
+	if claims.ExpiresAt.IsZero() {
+		return "", fmt.Errorf("pre-signed URLs need an expiry")
+	}
+	if claims.Prefix != "" && !validPrefix(claims.Prefix) {
+		return "", fmt.Errorf("invalid prefix %q", claims.Prefix)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing upload URL: %v", err)
+	}
+
+	query := u.Query()
+	for _, key := range []string{presignUploadID, presignFileName, presignMaxSize, presignContentTypes, presignPrefix, presignOwner, presignExpires, presignSignature} {
+		query.Del(key)
+	}
+	query.Set(presignUploadID, claims.UploadID)
+	query.Set(presignExpires, strconv.FormatInt(claims.ExpiresAt.Unix(), 10))
+	setIfNotEmpty(query, presignFileName, claims.FileName)
+	if claims.MaxSize > 0 {
+		query.Set(presignMaxSize, strconv.FormatInt(claims.MaxSize, 10))
+	}
+	setIfNotEmpty(query, presignContentTypes, strings.Join(claims.ContentTypes, ","))
+	setIfNotEmpty(query, presignPrefix, claims.Prefix)
+	setIfNotEmpty(query, presignOwner, claims.Owner)
+
+	query.Set(presignSignature, signToken(secret, presignCanonical(query)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func setIfNotEmpty(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// presignCanonical is the string a pre-signed URL's signature covers: the
+// upload parameters in sorted, encoded form. Parameters the application adds
+// for itself are not signed.
+func presignCanonical(query url.Values) string {
+	signed := url.Values{}
+	for _, key := range []string{presignUploadID, presignFileName, presignMaxSize, presignContentTypes, presignPrefix, presignOwner, presignExpires} {
+		if values, ok := query[key]; ok {
+			signed[key] = values
+		}
+	}
+	return signed.Encode()
+}
+
+// parsePresignedQuery verifies the signature and expiry of a pre-signed URL's
+// query and returns the claims it grants.
+func parsePresignedQuery(secret []byte, query url.Values, now time.Time) (UploadClaims, error) {
+	if !hmac.Equal([]byte(query.Get(presignSignature)), []byte(signToken(secret, presignCanonical(query)))) {
+		return UploadClaims{}, ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(query.Get(presignExpires), 10, 64)
+	if err != nil {
+		return UploadClaims{}, ErrInvalidToken
+	}
+	claims := UploadClaims{
+		UploadID:  query.Get(presignUploadID),
+		FileName:  query.Get(presignFileName),
+		Prefix:    query.Get(presignPrefix),
+		Owner:     query.Get(presignOwner),
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	if maxSize := query.Get(presignMaxSize); maxSize != "" {
+		if claims.MaxSize, err = strconv.ParseInt(maxSize, 10, 64); err != nil {
+			return UploadClaims{}, ErrInvalidToken
+		}
+	}
+	if contentTypes := query.Get(presignContentTypes); contentTypes != "" {
+		claims.ContentTypes = strings.Split(contentTypes, ",")
+	}
+	if claims.UploadID == "" || (claims.Prefix != "" && !validPrefix(claims.Prefix)) {
+		return UploadClaims{}, ErrInvalidToken
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return UploadClaims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
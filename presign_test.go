@@ -0,0 +1,95 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unreadableBody fails the test if the handler reads the request body.
+type unreadableBody struct{ t *testing.T }
+
+func (b unreadableBody) Read([]byte) (int, error) {
+	b.t.Error("Request body should not be read before the URL is verified")
+	return 0, io.EOF
+}
+
+func (b unreadableBody) Close() error { return nil }
+
+func TestPresignUploadURL(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("presign-secret")
+	u := NewUploader(Config{
+		TempDir:     filepath.Join(dir, "chunks"),
+		UploadDir:   filepath.Join(dir, "uploads"),
+		TokenSecret: secret,
+	})
+
+	signed, err := PresignUploadURL(secret, "https://uploads.example.com/chunks?tenant=acme", UploadClaims{
+		UploadID:  "presigned",
+		MaxSize:   100,
+		Owner:     "user-7",
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Failed to presign URL: %v", err)
+	}
+	parsed, _ := url.Parse(signed)
+	if parsed.Query().Get("tenant") != "acme" || parsed.Query().Get("signature") == "" {
+		t.Fatalf("Expected signed URL to keep its own parameters, got %s", signed)
+	}
+
+	upload := func(target *url.URL, uploadID string) (map[string]interface{}, error) {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    uploadID,
+			"fileName":    "photo.jpg",
+			"chunkIndex":  "0",
+			"totalChunks": "1",
+			"fileSize":    "5",
+		}, []byte("Hello"))
+		req.URL = target
+		return u.Handle(req)
+	}
+
+	// Tampering with a signed parameter is caught before the body is read
+	tampered := *parsed
+	query := tampered.Query()
+	query.Set("maxSize", "1000000")
+	tampered.RawQuery = query.Encode()
+	req, _ := createUploadForm(map[string]string{}, nil)
+	req.URL = &tampered
+	req.Body = unreadableBody{t}
+	if _, err := u.Handle(req); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for tampered URL, got %v", err)
+	}
+
+	retargeted := *parsed
+	query = retargeted.Query()
+	query.Set("uploadId", "someone-else")
+	retargeted.RawQuery = query.Encode()
+	if _, err := upload(&retargeted, "someone-else"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected URL to be bound to its upload, got %v", err)
+	}
+
+	result, err := upload(parsed, "presigned")
+	if err != nil {
+		t.Fatalf("Upload through pre-signed URL failed: %v", err)
+	}
+	if owner := result["metadata"].(map[string]interface{})["owner"]; owner != "user-7" {
+		t.Errorf("Expected owner from the URL, got %v", owner)
+	}
+
+	expired, _ := PresignUploadURL(secret, "/chunks", UploadClaims{UploadID: "late", ExpiresAt: time.Now().Add(-time.Second)})
+	expiredURL, _ := url.Parse(expired)
+	if _, err := upload(expiredURL, "late"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	if _, err := PresignUploadURL(secret, "/chunks", UploadClaims{ExpiresAt: time.Now()}); err == nil || !strings.Contains(err.Error(), "uploadId") {
+		t.Errorf("Expected uploadId to be required, got %v", err)
+	}
+}
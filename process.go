@@ -0,0 +1,219 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Processor is a post-processing step for assembled files, such as
+// thumbnailing, metadata extraction or re-encoding.
+type Processor interface {
+	Process(ctx context.Context, file *ProcessedFile) error
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(ctx context.Context, file *ProcessedFile) error
+
+// Process calls f.
+func (f ProcessorFunc) Process(ctx context.Context, file *ProcessedFile) error {
+	return f(ctx, file)
+}
+
+// ProcessedFile is the assembled file handed to each processing step.
+type ProcessedFile struct {
+	Session SessionInfo
+	Path    string // the stored file
+
+	// Metadata is the upload metadata. Steps may add fields to it; fields
+	// added by synchronous steps are part of the completion result.
+	Metadata map[string]interface{}
+
+	// Derived lists the files created from the stored file so far.
+	Derived []DerivedFile
+
+	step string
+}
+
+// DerivedFile is a file a processing step created from an upload.
+type DerivedFile struct {
+	Kind string `json:"kind"` // what the file is, for example "thumbnail"
+	Path string `json:"path"`
+	Step string `json:"step"` // the step that created it
+}
+
+// AddDerived records a file created by the current step.
+func (f *ProcessedFile) AddDerived(kind, path string) {
+	f.Derived = append(f.Derived, DerivedFile{Kind: kind, Path: path, Step: f.step})
+}
+
+// ProcessingStep configures one Processor in the pipeline. Steps run in
+// order after the file has been scanned, synchronous steps before OnComplete
+// and background steps after the upload has been reported complete.
+type ProcessingStep struct {
+	Name      string // identifies the step in metadata, logs and traces
+	Processor Processor
+
+	// Background steps do not delay the upload. Their results are published
+	// in an upload.processed event once all of them have run.
+	Background bool
+
+	// Required makes a failure of a synchronous step fail the upload; the
+	// stored file and derived files are deleted. Failures of other steps
+	// are recorded under "processingErrors" in the metadata.
+	Required bool
+
+	Timeout time.Duration // limit for each attempt, none if zero
+	Retry   RetryPolicy
+}
+
+// RetryPolicy decides whether and when a failed processing step is retried.
+type RetryPolicy struct {
+	MaxAttempts    int              // attempts including the first, default 1
+	InitialBackoff time.Duration    // delay before the first retry, default 100ms
+	MaxBackoff     time.Duration    // upper bound for the retry delay, default 5s
+	Retryable      func(error) bool // which errors are worth retrying, all if nil
+}
+
+// processFile runs steps over the file described by metadata. It returns the
+// files the steps created and an error if a required step failed.
+func (u *Uploader) processFile(ctx context.Context, info SessionInfo, metadata map[string]interface{}, derived []DerivedFile, steps []ProcessingStep) ([]DerivedFile, error) {
+	file := &ProcessedFile{
+		Session:  info,
+		Path:     metadata["path"].(string),
+		Metadata: metadata,
+		Derived:  derived,
+	}
+	failures, _ := metadata["processingErrors"].(map[string]string)
+
+	for _, step := range steps {
+		file.step = step.Name
+		err := u.runStep(ctx, step, file)
+		if err == nil {
+			continue
+		}
+		u.sessionLogger(info).Warn("processing step failed", "step", step.Name, "error", err)
+		if step.Required && !step.Background {
+			return file.Derived, fmt.Errorf("processing step %s failed: %w", step.Name, err)
+		}
+		if failures == nil {
+			failures = make(map[string]string)
+			metadata["processingErrors"] = failures
+		}
+		failures[step.Name] = err.Error()
+	}
+
+	if len(file.Derived) > 0 {
+		metadata["derivedFiles"] = file.Derived
+	}
+	return file.Derived, nil
+}
+
+// runStep runs a single step, retrying it according to its policy.
+func (u *Uploader) runStep(ctx context.Context, step ProcessingStep, file *ProcessedFile) error {
+	ctx, span := u.tracer.Start(ctx, "chunkeduploader.Process "+step.Name, trace.WithAttributes(attrStep.String(step.Name)))
+	policy := step.Retry
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 5 * time.Second
+	}
+
+	backoff := policy.InitialBackoff
+	attempts := 0
+	for {
+		attempts++
+		stepCtx, cancel := withTimeout(ctx, step.Timeout)
+		err := step.Processor.Process(stepCtx, file)
+		cancel()
+		if err == nil {
+			endSpan(span, nil)
+			return nil
+		}
+		if attempts >= policy.MaxAttempts || (policy.Retryable != nil && !policy.Retryable(err)) {
+			span.SetAttributes(attrAttempts.Int(attempts))
+			endSpan(span, err)
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			endSpan(span, ctx.Err())
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// splitSteps separates the synchronous steps from the background ones.
+func splitSteps(steps []ProcessingStep) (sync, background []ProcessingStep) {
+	for _, step := range steps {
+		if step.Background {
+			background = append(background, step)
+		} else {
+			sync = append(sync, step)
+		}
+	}
+	return sync, background
+}
+
+// processInBackground runs the background steps of a completed upload and
+// publishes their results as an upload.processed event. Shutdown waits for
+// it, and cancels it at its deadline.
+func (u *Uploader) processInBackground(info SessionInfo, metadata map[string]interface{}, steps []ProcessingStep) {
+	// Hooks and the completion result keep their own copy of the metadata
+	metadata = maps.Clone(metadata)
+	if failures, ok := metadata["processingErrors"].(map[string]string); ok {
+		metadata["processingErrors"] = maps.Clone(failures)
+	}
+	derived, _ := metadata["derivedFiles"].([]DerivedFile)
+	derived = append([]DerivedFile(nil), derived...)
+
+	u.inflight.Add(1)
+	go func() {
+		defer u.inflight.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer context.AfterFunc(u.stopCtx, cancel)()
+
+		event := newEvent(EventProcessed, info)
+		work, err := u.checkoutContent(info, metadata)
+		if err == nil {
+			u.processFile(ctx, info, metadata, derived, steps)
+			if work != "" {
+				err = u.checkinContent(metadata, work)
+			}
+		}
+
+		event.File = fileMetadataFrom(metadata)
+		event.Metadata = metadata
+		if err != nil {
+			u.sessionLogger(info).Warn("background processing failed", "error", err)
+			event.Error = err.Error()
+		} else if failures, ok := metadata["processingErrors"].(map[string]string); ok {
+			event.Error = fmt.Sprintf("%d processing steps failed", len(failures))
+		}
+		u.emit(ctx, event)
+	}()
+}
+
+// removeDerivedFiles deletes files created by processing steps.
+func removeDerivedFiles(derived []DerivedFile) {
+	for _, file := range derived {
+		os.RemoveAll(file.Path)
+	}
+}
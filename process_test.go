@@ -0,0 +1,166 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func processingUploader(t *testing.T, steps ...ProcessingStep) (*Uploader, *ChannelSink, string) {
+	dir := t.TempDir()
+	events := NewChannelSink(8)
+	u := NewUploader(Config{
+		TempDir:    filepath.Join(dir, "chunks"),
+		UploadDir:  filepath.Join(dir, "uploads"),
+		EventSink:  events,
+		Processors: steps,
+	})
+	return u, events, dir
+}
+
+func uploadSingleChunk(u *Uploader, fileName, data string) (map[string]interface{}, error) {
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    fileName,
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    fmt.Sprint(len(data)),
+	}, []byte(data))
+	return u.Handle(req)
+}
+
+// upperCaseCopy writes an upper-cased copy of the file next to it.
+var upperCaseCopy = ProcessorFunc(func(ctx context.Context, file *ProcessedFile) error {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return err
+	}
+	path := file.Path + ".upper"
+	if err := os.WriteFile(path, []byte(strings.ToUpper(string(content))), 0644); err != nil {
+		return err
+	}
+	file.AddDerived("uppercase", path)
+	file.Metadata["lines"] = strings.Count(string(content), "\n") + 1
+	return nil
+})
+
+func TestUploader_ProcessingSteps(t *testing.T) {
+	attempts := 0
+	flaky := ProcessorFunc(func(ctx context.Context, file *ProcessedFile) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("temporarily unavailable")
+		}
+		file.Metadata["flaky"] = "done"
+		return nil
+	})
+	u, _, _ := processingUploader(t,
+		ProcessingStep{Name: "uppercase", Processor: upperCaseCopy},
+		ProcessingStep{Name: "flaky", Processor: flaky, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}},
+	)
+
+	result, err := uploadSingleChunk(u, "notes.txt", "one\ntwo")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	metadata := result["metadata"].(map[string]interface{})
+	if metadata["lines"] != 2 || metadata["flaky"] != "done" || attempts != 3 {
+		t.Errorf("Expected fields from both steps after retries, got %v after %d attempts", metadata, attempts)
+	}
+	derived, _ := metadata["derivedFiles"].([]DerivedFile)
+	if len(derived) != 1 || derived[0].Kind != "uppercase" || derived[0].Step != "uppercase" {
+		t.Fatalf("Expected the derived file in metadata, got %v", metadata["derivedFiles"])
+	}
+	if content, _ := os.ReadFile(derived[0].Path); string(content) != "ONE\nTWO" {
+		t.Errorf("Expected derived file content, got %q", content)
+	}
+}
+
+func TestUploader_ProcessingFailures(t *testing.T) {
+	attempts := 0
+	permanent := errors.New("unsupported format")
+	optional := ProcessorFunc(func(ctx context.Context, file *ProcessedFile) error {
+		attempts++
+		return permanent
+	})
+	u, _, dir := processingUploader(t,
+		ProcessingStep{Name: "optional", Processor: optional, Retry: RetryPolicy{
+			MaxAttempts: 5,
+			Retryable:   func(err error) bool { return !errors.Is(err, permanent) },
+		}},
+	)
+
+	result, err := uploadSingleChunk(u, "notes.txt", "hello")
+	if err != nil {
+		t.Fatalf("An optional step should not fail the upload: %v", err)
+	}
+	failures := result["metadata"].(map[string]interface{})["processingErrors"].(map[string]string)
+	if failures["optional"] != "unsupported format" || attempts != 1 {
+		t.Errorf("Expected the failure recorded without retries, got %v after %d attempts", failures, attempts)
+	}
+
+	required, _, requiredDir := processingUploader(t,
+		ProcessingStep{Name: "uppercase", Processor: upperCaseCopy},
+		ProcessingStep{Name: "encode", Processor: ProcessorFunc(func(ctx context.Context, file *ProcessedFile) error {
+			return errors.New("encoder crashed")
+		}), Required: true},
+	)
+	_, err = uploadSingleChunk(required, "notes.txt", "hello")
+	if err == nil || !strings.Contains(err.Error(), "processing step encode failed") {
+		t.Fatalf("Expected the required step to fail the upload, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(requiredDir, "uploads")); len(entries) != 0 {
+		t.Errorf("Expected the stored and derived files to be deleted, found %d files", len(entries))
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 1 {
+		t.Errorf("Expected the optional step's upload to be stored, found %d files", len(entries))
+	}
+}
+
+func TestUploader_BackgroundProcessing(t *testing.T) {
+	release := make(chan struct{})
+	slow := ProcessorFunc(func(ctx context.Context, file *ProcessedFile) error {
+		<-release
+		file.Metadata["slow"] = true
+		return nil
+	})
+	u, events, _ := processingUploader(t,
+		ProcessingStep{Name: "uppercase", Processor: upperCaseCopy, Background: true},
+		ProcessingStep{Name: "slow", Processor: slow, Background: true},
+	)
+
+	result, err := uploadSingleChunk(u, "notes.txt", "hello")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if _, ok := result["metadata"].(map[string]interface{})["slow"]; ok {
+		t.Errorf("Background steps should not delay the upload")
+	}
+	if event := <-events.C; event.Type != EventCompleted {
+		t.Fatalf("Expected completed event first, got %s", event.Type)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- u.Shutdown(context.Background()) }()
+	select {
+	case <-shutdown:
+		t.Fatalf("Shutdown should wait for background processing")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+
+	event := <-events.C
+	if event.Type != EventProcessed || event.Metadata["slow"] != true || event.Error != "" {
+		t.Fatalf("Expected processed event with the step results, got %+v", event)
+	}
+	if derived, _ := event.Metadata["derivedFiles"].([]DerivedFile); len(derived) != 1 {
+		t.Errorf("Expected derived files in the processed event, got %v", event.Metadata["derivedFiles"])
+	}
+}
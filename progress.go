@@ -0,0 +1,277 @@
+package chunkeduploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Progress is a server-side snapshot of an upload, as streamed to clients.
+type Progress struct {
+	UploadID       string       `json:"uploadId,omitempty"`
+	FileName       string       `json:"fileName"`
+	State          SessionState `json:"state"`
+	ReceivedChunks int          `json:"receivedChunks"`
+	TotalChunks    int          `json:"totalChunks"`
+	ReceivedBytes  int64        `json:"receivedBytes"`
+	FileSize       int64        `json:"fileSize"`
+
+	// AssembledChunks and AssembledBytes report how much of the final file
+	// has been written while the upload is assembling.
+	AssembledChunks int   `json:"assembledChunks"`
+	AssembledBytes  int64 `json:"assembledBytes"`
+
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// Done reports whether the upload has reached a final state.
+func (p Progress) Done() bool {
+	switch p.State {
+	case StateComplete, StateFailed, StateAborted, StateExpired:
+		return true
+	}
+	return false
+}
+
+// progressBufferSize is how many updates a slow subscriber may fall behind
+// before the oldest ones are dropped.
+const progressBufferSize = 16
+
+// ProgressBroker fans progress updates out to subscribers of each upload.
+type ProgressBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan Progress]struct{} // session key -> channels
+}
+
+func NewProgressBroker() *ProgressBroker {
+	return &ProgressBroker{
+		subscribers: make(map[string]map[chan Progress]struct{}),
+	}
+}
+
+// Subscribe returns a channel of progress updates for the upload stored
+// under key, and a function that ends the subscription.
+func (b *ProgressBroker) Subscribe(key string) (<-chan Progress, func()) {
+	ch := make(chan Progress, progressBufferSize)
+
+	b.mutex.Lock()
+	if b.subscribers[key] == nil {
+		b.subscribers[key] = make(map[chan Progress]struct{})
+	}
+	b.subscribers[key][ch] = struct{}{}
+	b.mutex.Unlock()
+
+	return ch, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers[key], ch)
+		if len(b.subscribers[key]) == 0 {
+			delete(b.subscribers, key)
+		}
+	}
+}
+
+// Publish sends p to every subscriber of key without blocking. A subscriber
+// that has fallen behind loses its oldest update, so the latest state,
+// including the final one, always gets through.
+func (b *ProgressBroker) Publish(key string, p Progress) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers[key] {
+		for {
+			select {
+			case ch <- p:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// Progress returns the current progress of the upload stored under key,
+// which is its uploadId, or its file name if it was sent without one.
+func (u *Uploader) Progress(key string) (Progress, bool) {
+	return u.files.progress(key)
+}
+
+// publishProgress sends the current state of s to its progress subscribers.
+func (u *Uploader) publishProgress(s *uploadSession) {
+	p := u.files.snapshot(s)
+	u.progress.Publish(p.key(), p)
+}
+
+func (p Progress) key() string {
+	if p.UploadID != "" {
+		return p.UploadID
+	}
+	return p.FileName
+}
+
+// watchProgress subscribes to the upload named by the request's uploadId (or
+// fileName) parameter and returns its current progress, if any, together
+// with the update channel.
+func (u *Uploader) watchProgress(r *http.Request) (current Progress, known bool, updates <-chan Progress, cancel func(), err error) {
+	key := r.URL.Query().Get("uploadId")
+	if key == "" {
+		key = r.URL.Query().Get("fileName")
+	}
+	if key == "" {
+		return Progress{}, false, nil, nil, fmt.Errorf("uploadId is required")
+	}
+	if err := u.authorizeProgress(r, key); err != nil {
+		return Progress{}, false, nil, nil, err
+	}
+
+	// Subscribe before taking the snapshot so no update falls in between.
+	updates, cancel = u.progress.Subscribe(key)
+	current, known = u.Progress(key)
+	return current, known, updates, cancel, nil
+}
+
+// authorizeProgress checks that the request may watch the upload stored
+// under key. With tokens enabled it needs a token of the upload's owner, as
+// for aborting it. Uploads that have not started yet can only be watched
+// with a token bound to their uploadId, so nobody can subscribe to an
+// upload another owner starts later.
+func (u *Uploader) authorizeProgress(r *http.Request, key string) error {
+	if len(u.config.TokenSecret) == 0 {
+		return nil
+	}
+	claims, err := u.authorize(r)
+	if err != nil {
+		return err
+	}
+	if claims.UploadID != "" && claims.UploadID != r.URL.Query().Get("uploadId") {
+		return fmt.Errorf("%w: token is for another upload", ErrInvalidToken)
+	}
+	owner, exists := u.files.owner(key)
+	if !exists {
+		if claims.UploadID != key {
+			return ErrUploadNotFound
+		}
+		return nil
+	}
+	if owner != claims.Owner {
+		return fmt.Errorf("%w: upload belongs to another owner", ErrInvalidToken)
+	}
+	return nil
+}
+
+// ServeProgressSSE streams the progress of one upload as Server-Sent Events.
+// The upload is named by the uploadId (or fileName) query parameter. Each
+// event carries a JSON Progress; the stream ends once the upload is done.
+// With tokens enabled, the request needs a token of the upload's owner.
+func (u *Uploader) ServeProgressSSE(w http.ResponseWriter, r *http.Request) {
+	current, known, updates, cancel, err := u.watchProgress(r)
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	defer cancel()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(p Progress) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if known {
+		if err := send(current); err != nil || current.Done() {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case p := <-updates:
+			if err := send(p); err != nil || p.Done() {
+				return
+			}
+		}
+	}
+}
+
+// ServeProgressWebSocket streams the progress of one upload over a WebSocket.
+// The upload is named by the uploadId (or fileName) query parameter. Each
+// text message is a JSON Progress; the server closes the connection once the
+// upload is done. Tokens are required as for ServeProgressSSE, and browsers
+// may only connect from the server's own origin or Config.AllowedOrigins.
+func (u *Uploader) ServeProgressWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !u.allowedOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	current, known, updates, cancel, err := u.watchProgress(r)
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	defer cancel()
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	send := func(p Progress) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(wsOpText, data)
+	}
+
+	if known {
+		if err := send(current); err != nil {
+			return
+		}
+		if current.Done() {
+			conn.WriteClose(wsCloseNormal)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-conn.Closed():
+			return
+		case p := <-updates:
+			if err := send(p); err != nil {
+				return
+			}
+			if p.Done() {
+				conn.WriteClose(wsCloseNormal)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProgressTestUploader(t *testing.T) *Uploader {
+	dir := t.TempDir()
+	return NewUploader(Config{TempDir: filepath.Join(dir, "chunks"), UploadDir: filepath.Join(dir, "uploads")})
+}
+
+func uploadTwoChunks(t *testing.T, u *Uploader, uploadID string) {
+	for i, data := range []string{"Hello, ", "World!"} {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    uploadID,
+			"fileName":    "progress.txt",
+			"chunkIndex":  fmt.Sprintf("%d", i),
+			"totalChunks": "2",
+			"fileSize":    "13",
+		}, []byte(data))
+		if _, err := u.Handle(req); err != nil {
+			t.Fatalf("Upload of chunk %d failed: %v", i, err)
+		}
+	}
+}
+
+func checkProgressSequence(t *testing.T, updates []Progress) {
+	if len(updates) < 3 {
+		t.Fatalf("Expected at least 3 progress updates, got %+v", updates)
+	}
+	if first := updates[0]; first.ReceivedChunks != 1 || first.ReceivedBytes != 7 || first.TotalChunks != 2 {
+		t.Errorf("Expected first chunk progress, got %+v", first)
+	}
+	last := updates[len(updates)-1]
+	if last.State != StateComplete || last.ReceivedBytes != 13 || last.Metadata["storedName"] == nil {
+		t.Errorf("Expected final progress with metadata, got %+v", last)
+	}
+}
+
+func TestUploader_ProgressSSE(t *testing.T) {
+	u := newProgressTestUploader(t)
+	server := httptest.NewServer(http.HandlerFunc(u.ServeProgressSSE))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?uploadId=sse-upload")
+	if err != nil {
+		t.Fatalf("Failed to open SSE stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	uploadTwoChunks(t, u, "sse-upload")
+
+	var updates []Progress
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var p Progress
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &p); err != nil {
+			t.Fatalf("Invalid progress payload: %v", err)
+		}
+		updates = append(updates, p)
+	}
+	checkProgressSequence(t, updates)
+}
+
+func TestUploader_ProgressWebSocket(t *testing.T) {
+	u := newProgressTestUploader(t)
+	server := httptest.NewServer(http.HandlerFunc(u.ServeProgressWebSocket))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /?uploadId=ws-upload HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	// Clients must mask frames; a ping checks the read side of the connection
+	writeWebSocketFrame(conn, wsOpPing, []byte("hi"), []byte{1, 2, 3, 4})
+	if opcode, payload, err := readWebSocketFrame(reader, 1<<20); err != nil || opcode != wsOpPong || string(payload) != "hi" {
+		t.Fatalf("Expected pong, got opcode %d payload %q err %v", opcode, payload, err)
+	}
+
+	uploadTwoChunks(t, u, "ws-upload")
+
+	var updates []Progress
+	for {
+		opcode, payload, err := readWebSocketFrame(reader, 1<<20)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if opcode == wsOpClose {
+			break
+		}
+		var p Progress
+		if err := json.Unmarshal(payload, &p); err != nil {
+			t.Fatalf("Invalid progress payload: %v", err)
+		}
+		updates = append(updates, p)
+	}
+	checkProgressSequence(t, updates)
+}
+
+func TestUploader_ProgressSnapshot(t *testing.T) {
+	u := newProgressTestUploader(t)
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "snapshot-upload",
+		"fileName":    "progress.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "13",
+	}, []byte("Hello, "))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	p, ok := u.Progress("snapshot-upload")
+	if !ok || p.State != StateReceiving || p.ReceivedChunks != 1 || p.ReceivedBytes != 7 || p.Done() {
+		t.Errorf("Unexpected progress %+v", p)
+	}
+	if _, ok := u.Progress("unknown"); ok {
+		t.Error("Expected no progress for unknown upload")
+	}
+}
+
+func TestProgressBroker_DropsOldestForSlowSubscribers(t *testing.T) {
+	broker := NewProgressBroker()
+	updates, cancel := broker.Subscribe("slow")
+	defer cancel()
+
+	for i := 0; i < progressBufferSize*2; i++ {
+		broker.Publish("slow", Progress{ReceivedChunks: i})
+	}
+	broker.Publish("slow", Progress{State: StateComplete})
+
+	var last Progress
+	for len(updates) > 0 {
+		last = <-updates
+	}
+	if last.State != StateComplete {
+		t.Errorf("Expected the final update to be kept, got %+v", last)
+	}
+}
+
+func TestUploader_ProgressRequiresToken(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("token-secret")
+	u := NewUploader(Config{
+		TempDir:     filepath.Join(dir, "chunks"),
+		UploadDir:   filepath.Join(dir, "uploads"),
+		TokenSecret: secret,
+	})
+	mint := func(claims UploadClaims) string {
+		claims.ExpiresAt = time.Now().Add(time.Minute)
+		token, _ := NewUploadToken(secret, claims)
+		return token
+	}
+	owner := mint(UploadClaims{UploadID: "watched", Owner: "user-42"})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "watched",
+		"fileName":    "progress.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "13",
+	}, []byte("Hello, "))
+	req.Header.Set("Authorization", "Bearer "+owner)
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(u.ServeProgressSSE))
+	defer server.Close()
+	status := func(query, token string) int {
+		req, _ := http.NewRequest("GET", server.URL+"?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status("uploadId=watched", ""); got != http.StatusUnauthorized {
+		t.Errorf("Expected a token to be required, got %d", got)
+	}
+	if got := status("uploadId=watched", mint(UploadClaims{Owner: "intruder"})); got != http.StatusUnauthorized {
+		t.Errorf("Expected another owner's token to be rejected, got %d", got)
+	}
+	if got := status("uploadId=later", mint(UploadClaims{Owner: "intruder"})); got != http.StatusNotFound {
+		t.Errorf("Expected unknown uploads to need a token bound to them, got %d", got)
+	}
+	if got := status("uploadId=later", mint(UploadClaims{UploadID: "later", Owner: "user-42"})); got != http.StatusOK {
+		t.Errorf("Expected a token bound to an upload to watch it before it starts, got %d", got)
+	}
+
+	// The stream would stay open until the upload finishes, so only the
+	// response headers are checked
+	req, _ = http.NewRequest("GET", server.URL+"?uploadId=watched", nil)
+	req.Header.Set("Authorization", "Bearer "+owner)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the owner to watch the upload, got %d", resp.StatusCode)
+	}
+}
+
+func TestUploader_ProgressWebSocketOrigin(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:        filepath.Join(dir, "chunks"),
+		UploadDir:      filepath.Join(dir, "uploads"),
+		AllowedOrigins: []string{"https://app.example.com"},
+	})
+	server := httptest.NewServer(http.HandlerFunc(u.ServeProgressWebSocket))
+	defer server.Close()
+
+	handshake := func(origin string) int {
+		req, _ := http.NewRequest("GET", server.URL+"?uploadId=ws-origin", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Handshake failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := handshake("https://evil.example.net"); got != http.StatusForbidden {
+		t.Errorf("Expected a foreign origin to be refused, got %d", got)
+	}
+	if got := handshake("https://app.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected an allowed origin to connect, got %d", got)
+	}
+	if got := handshake(server.URL); got != http.StatusSwitchingProtocols {
+		t.Errorf("Expected the server's own origin to connect, got %d", got)
+	}
+}
@@ -0,0 +1,240 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// ErrQuotaExceeded is returned for uploads that would take an owner, or
+	// all owners together, over their storage quota.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrInsufficientStorage is returned while free space on the temp or
+	// upload volume is below Quota.MinFreeBytes.
+	ErrInsufficientStorage = errors.New("insufficient storage")
+)
+
+// Quota limits the storage uploads may use. Zero values disable a limit.
+//
+// An upload reserves its declared file size when its session starts. The
+// reservation covers the chunks staged in the temp directory and is turned
+// into finalized bytes once the file is stored, or released if the upload
+// fails, is aborted or expires. Quotas apply to reserved plus finalized bytes.
+type Quota struct {
+	PerOwner int64 // bytes each owner may use
+	Global   int64 // bytes all owners together may use
+
+	// MinFreeBytes is the free space the temp and upload volumes must keep;
+	// chunks are refused below it. Free space is checked on Linux and macOS.
+	MinFreeBytes uint64
+
+	// OwnerParam names the additionalParams field that identifies the owner
+	// of uploads sent without an upload token.
+	OwnerParam string
+}
+
+func (q Quota) enabled() bool {
+	return q.PerOwner > 0 || q.Global > 0 || q.MinFreeBytes > 0
+}
+
+// QuotaUsage is the storage attributed to an owner, or to all owners.
+type QuotaUsage struct {
+	Reserved  int64 `json:"reserved"`  // declared size of uploads in progress
+	Staged    int64 `json:"staged"`    // chunk bytes in the temp directory
+	Finalized int64 `json:"finalized"` // bytes of stored files
+}
+
+// Used is the amount counted against a quota.
+func (q QuotaUsage) Used() int64 {
+	return q.Reserved + q.Finalized
+}
+
+type quotaReservation struct {
+	owner  string
+	size   int64
+	staged int64
+}
+
+// quotaTracker accounts storage by owner and session key. Its methods do
+// nothing on a nil *quotaTracker, which is used when no quota is configured.
+type quotaTracker struct {
+	config       Quota
+	mutex        sync.Mutex
+	reservations map[string]*quotaReservation // session key -> reservation
+	owners       map[string]*QuotaUsage
+	total        QuotaUsage
+}
+
+func newQuotaTracker(config Quota) *quotaTracker {
+	return &quotaTracker{
+		config:       config,
+		reservations: make(map[string]*quotaReservation),
+		owners:       make(map[string]*QuotaUsage),
+	}
+}
+
+func (q *quotaTracker) ownerLocked(owner string) *QuotaUsage {
+	usage, exists := q.owners[owner]
+	if !exists {
+		usage = &QuotaUsage{}
+		q.owners[owner] = usage
+	}
+	return usage
+}
+
+// reserve books size bytes for the session stored under key, unless it
+// already has a reservation.
+func (q *quotaTracker) reserve(key string, owner string, size int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, exists := q.reservations[key]; exists {
+		return nil
+	}
+	usage := q.ownerLocked(owner)
+	if q.config.PerOwner > 0 && usage.Used()+size > q.config.PerOwner {
+		return fmt.Errorf("%w: owner %q has %d of %d bytes left", ErrQuotaExceeded, owner, max(q.config.PerOwner-usage.Used(), 0), q.config.PerOwner)
+	}
+	if q.config.Global > 0 && q.total.Used()+size > q.config.Global {
+		return fmt.Errorf("%w: global budget has %d of %d bytes left", ErrQuotaExceeded, max(q.config.Global-q.total.Used(), 0), q.config.Global)
+	}
+
+	q.reservations[key] = &quotaReservation{owner: owner, size: size}
+	usage.Reserved += size
+	q.total.Reserved += size
+	return nil
+}
+
+// setStaged records how many chunk bytes the session stored under key holds.
+func (q *quotaTracker) setStaged(key string, staged int64) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	r, exists := q.reservations[key]
+	if !exists {
+		return
+	}
+	delta := staged - r.staged
+	r.staged = staged
+	q.ownerLocked(r.owner).Staged += delta
+	q.total.Staged += delta
+}
+
+// finish ends the reservation of the session stored under key. stored is the
+// size of the file it produced, or zero if it produced none.
+func (q *quotaTracker) finish(key string, stored int64) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	r, exists := q.reservations[key]
+	if !exists {
+		return
+	}
+	delete(q.reservations, key)
+
+	usage := q.ownerLocked(r.owner)
+	usage.Reserved -= r.size
+	usage.Staged -= r.staged
+	usage.Finalized += stored
+	q.total.Reserved -= r.size
+	q.total.Staged -= r.staged
+	q.total.Finalized += stored
+}
+
+// releaseStored gives back bytes of stored files that have been deleted.
+func (q *quotaTracker) releaseStored(owner string, bytes int64) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	usage := q.ownerLocked(owner)
+	bytes = min(bytes, usage.Finalized)
+	usage.Finalized -= bytes
+	q.total.Finalized -= bytes
+}
+
+func (q *quotaTracker) usage(owner string) QuotaUsage {
+	if q == nil {
+		return QuotaUsage{}
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if usage, exists := q.owners[owner]; exists {
+		return *usage
+	}
+	return QuotaUsage{}
+}
+
+func (q *quotaTracker) totalUsage() QuotaUsage {
+	if q == nil {
+		return QuotaUsage{}
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.total
+}
+
+// checkFreeSpace refuses new data while a volume the uploader writes to is
+// short of space.
+func (q *quotaTracker) checkFreeSpace(dirs ...string) error {
+	if q == nil {
+		return nil
+	}
+	if q.config.MinFreeBytes == 0 {
+		return nil
+	}
+	for _, dir := range dirs {
+		free, ok := freeSpace(existingAncestor(dir))
+		if ok && free < q.config.MinFreeBytes {
+			return fmt.Errorf("%w: %d bytes free on the volume of %s", ErrInsufficientStorage, free, dir)
+		}
+	}
+	return nil
+}
+
+// existingAncestor returns dir or its closest existing parent, so the volume
+// of a directory that is yet to be created can be checked.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// QuotaUsage returns the storage attributed to owner. Uploads without an
+// owner are accounted under the empty owner.
+func (u *Uploader) QuotaUsage(owner string) QuotaUsage {
+	return u.quota.usage(owner)
+}
+
+// TotalQuotaUsage returns the storage used by all owners together.
+func (u *Uploader) TotalQuotaUsage() QuotaUsage {
+	return u.quota.totalUsage()
+}
+
+// ReleaseStored gives back quota for stored files of owner that the
+// application has deleted. Finalized usage is kept in memory only.
+func (u *Uploader) ReleaseStored(owner string, bytes int64) {
+	u.quota.releaseStored(owner, bytes)
+}
@@ -0,0 +1,129 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestQuota_PerOwnerAndGlobal(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Quota:     Quota{PerOwner: 20, Global: 30, OwnerParam: "userId"},
+	})
+
+	upload := func(uploadID, userID string, index, total int, fileSize int, data string) error {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":         uploadID,
+			"fileName":         uploadID + ".txt",
+			"chunkIndex":       fmt.Sprintf("%d", index),
+			"totalChunks":      fmt.Sprintf("%d", total),
+			"fileSize":         fmt.Sprintf("%d", fileSize),
+			"additionalParams": fmt.Sprintf(`{"userId":%q}`, userID),
+		}, []byte(data))
+		_, err := u.Handle(req)
+		return err
+	}
+
+	if err := upload("first", "alice", 0, 1, 13, "Hello, World!"); err != nil {
+		t.Fatalf("Upload within quota failed: %v", err)
+	}
+	if usage := u.QuotaUsage("alice"); usage.Finalized != 13 || usage.Reserved != 0 || usage.Staged != 0 {
+		t.Errorf("Expected 13 finalized bytes, got %+v", usage)
+	}
+
+	// Reserving 10 more bytes would take alice to 23 of 20
+	if err := upload("second", "alice", 0, 2, 10, "Hello"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for owner quota, got %v", err)
+	}
+	if _, ok := u.Progress("second"); ok {
+		t.Error("A rejected upload should not start a session")
+	}
+
+	if err := upload("bobs", "bob", 0, 2, 10, "Hello"); err != nil {
+		t.Fatalf("Another owner should have quota left, got %v", err)
+	}
+	if usage := u.QuotaUsage("bob"); usage.Reserved != 10 || usage.Staged != 5 {
+		t.Errorf("Expected 10 reserved and 5 staged bytes, got %+v", usage)
+	}
+
+	// 13 finalized and 10 reserved leave 7 of the global 30
+	if err := upload("carols", "carol", 0, 1, 8, "12345678"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for global budget, got %v", err)
+	}
+
+	if err := u.Abort(context.Background(), "bobs"); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if usage := u.QuotaUsage("bob"); usage != (QuotaUsage{}) {
+		t.Errorf("Abort should release the reservation, got %+v", usage)
+	}
+
+	u.ReleaseStored("alice", 13)
+	if err := upload("second", "alice", 0, 1, 10, "0123456789"); err != nil {
+		t.Errorf("Released quota should be usable again, got %v", err)
+	}
+	if total := u.TotalQuotaUsage(); total.Used() != 10 {
+		t.Errorf("Expected 10 bytes in use overall, got %+v", total)
+	}
+}
+
+func TestQuota_ChunksMustFitReservation(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Quota:     Quota{PerOwner: 1 << 20},
+	})
+
+	for i := 0; i < 2; i++ {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "undersized",
+			"fileName":    "undersized.txt",
+			"chunkIndex":  fmt.Sprintf("%d", i),
+			"totalChunks": "3",
+			"fileSize":    "6",
+		}, []byte("Hello"))
+		rec := httptest.NewRecorder()
+		u.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if i == 1 {
+			want = http.StatusRequestEntityTooLarge
+		}
+		if rec.Code != want {
+			t.Errorf("Chunk %d: expected %d, got %d: %s", i, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestQuota_MinFreeBytes(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("free space is only checked on Linux and macOS")
+	}
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Quota:     Quota{MinFreeBytes: 1 << 62},
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    "full.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	rec := httptest.NewRecorder()
+	u.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507 when the volume is short of space, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,184 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is matched by RateLimitError.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError is returned for chunk requests rejected by a rate limit.
+// ServeHTTP turns it into a 429 response with a Retry-After header.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimit configures per-client limits on chunk requests. Zero values
+// disable the corresponding limit.
+type RateLimit struct {
+	RequestsPerSecond   float64 // sustained chunk requests per second
+	Burst               int     // requests allowed at once above the rate, default 1
+	MaxConcurrentChunks int     // chunk requests handled at the same time
+	BytesPerSecond      int64   // request body bytes read per second
+
+	// Key names the client a request is counted against. owner comes from
+	// an upload token or pre-signed URL sent outside the request body, and
+	// is empty otherwise. The default is RateLimitByIP.
+	Key func(r *http.Request, owner string) string
+}
+
+// RateLimitByIP keys requests by the client IP of the connection. Behind a
+// proxy, use a Key function that reads the proxy's client address header.
+func RateLimitByIP(r *http.Request, owner string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByOwner keys requests by token owner, falling back to the client
+// IP for requests without one.
+func RateLimitByOwner(r *http.Request, owner string) string {
+	if owner != "" {
+		return "owner:" + owner
+	}
+	return RateLimitByIP(r, owner)
+}
+
+func (l RateLimit) enabled() bool {
+	return l.RequestsPerSecond > 0 || l.MaxConcurrentChunks > 0 || l.BytesPerSecond > 0
+}
+
+// rateLimitIdle is how long a client's state is kept after its last request.
+const rateLimitIdle = time.Minute
+
+// rateLimiter tracks the limits of each client.
+type rateLimiter struct {
+	config    RateLimit
+	mutex     sync.Mutex
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+}
+
+type clientLimits struct {
+	requests tokenBucket
+	bytes    *tokenBucket // shared by the client's concurrent requests
+	active   int
+	lastSeen time.Time
+}
+
+func newRateLimiter(config RateLimit) *rateLimiter {
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	if config.Key == nil {
+		config.Key = RateLimitByIP
+	}
+	return &rateLimiter{config: config, clients: make(map[string]*clientLimits)}
+}
+
+// acquire admits a chunk request from the client key. The returned release
+// function must be called once the request is done; the returned bucket, if
+// not nil, throttles the request body.
+func (l *rateLimiter) acquire(key string) (release func(), bytes *tokenBucket, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweepLocked(now)
+
+	c, exists := l.clients[key]
+	if !exists {
+		c = &clientLimits{requests: tokenBucket{rate: l.config.RequestsPerSecond, burst: float64(l.config.Burst), tokens: float64(l.config.Burst), last: now}}
+		if l.config.BytesPerSecond > 0 {
+			rate := float64(l.config.BytesPerSecond)
+			c.bytes = &tokenBucket{rate: rate, burst: rate, tokens: rate, last: now}
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	if l.config.MaxConcurrentChunks > 0 && c.active >= l.config.MaxConcurrentChunks {
+		return nil, nil, &RateLimitError{RetryAfter: time.Second}
+	}
+	if l.config.RequestsPerSecond > 0 {
+		if wait := c.requests.take(now, 1); wait > 0 {
+			return nil, nil, &RateLimitError{RetryAfter: wait}
+		}
+	}
+
+	c.active++
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		c.active--
+		c.lastSeen = time.Now()
+	}, c.bytes, nil
+}
+
+// sweepLocked forgets clients that have been idle for a while.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitIdle {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.active == 0 && now.Sub(c.lastSeen) >= rateLimitIdle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	mutex  sync.Mutex // only used by reserve, which runs outside the limiter lock
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take removes n tokens if they are available, and otherwise returns how long
+// until they will be.
+func (b *tokenBucket) take(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve removes n tokens, going into debt if needed, and returns how long
+// the caller must wait for the debt to be paid off.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
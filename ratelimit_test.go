@@ -0,0 +1,133 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_RequestRate(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		RateLimit: RateLimit{RequestsPerSecond: 0.5, Burst: 1},
+	})
+
+	send := func(remoteAddr string, index int) *httptest.ResponseRecorder {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "limited-" + strings.NewReplacer(".", "-", ":", "-").Replace(remoteAddr),
+			"fileName":    "limited.txt",
+			"chunkIndex":  fmt.Sprintf("%d", index),
+			"totalChunks": "3",
+			"fileSize":    "15",
+		}, []byte("Hello"))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		u.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("10.0.0.1:5000", 0); rec.Code != http.StatusOK {
+		t.Fatalf("Expected first chunk to pass, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := send("10.0.0.1:5001", 1)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d: %s", rec.Code, rec.Body)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("Expected Retry-After of 2 seconds, got %q", retry)
+	}
+	if rec := send("10.0.0.2:5000", 0); rec.Code != http.StatusOK {
+		t.Errorf("Another client should not be limited, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRateLimit_ConcurrentChunksByOwner(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("limit-secret")
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	u := NewUploader(Config{
+		TempDir:     filepath.Join(dir, "chunks"),
+		UploadDir:   filepath.Join(dir, "uploads"),
+		TokenSecret: secret,
+		RateLimit:   RateLimit{MaxConcurrentChunks: 1, Key: RateLimitByOwner},
+		Hooks: Hooks{
+			OnChunkReceived: func(ctx context.Context, session SessionInfo, chunk ChunkInfo) error {
+				if session.Owner == "busy" {
+					entered <- struct{}{}
+					<-release
+				}
+				return nil
+			},
+		},
+	})
+
+	send := func(owner string, index int) *httptest.ResponseRecorder {
+		token, _ := NewUploadToken(secret, UploadClaims{Owner: owner, ExpiresAt: time.Now().Add(time.Minute)})
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "concurrent-" + owner,
+			"fileName":    "concurrent.txt",
+			"chunkIndex":  fmt.Sprintf("%d", index),
+			"totalChunks": "2",
+			"fileSize":    "10",
+		}, []byte("Hello"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		u.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send("busy", 0) }()
+	<-entered
+
+	if rec := send("busy", 1); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After for a second concurrent chunk, got %d", rec.Code)
+	}
+	if rec := send("idle", 0); rec.Code != http.StatusOK {
+		t.Errorf("Another owner should not be limited, got %d: %s", rec.Code, rec.Body)
+	}
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Errorf("Expected first chunk to pass, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send("busy", 1); rec.Code != http.StatusOK {
+		t.Errorf("Expected chunk to pass once the first finished, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRateLimit_ThrottlesBody(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		RateLimit: RateLimit{BytesPerSecond: 64 << 10},
+	})
+
+	data := bytes.Repeat([]byte("x"), 96<<10)
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "throttled",
+		"fileName":    "throttled.bin",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    fmt.Sprintf("%d", len(data)),
+	}, data)
+
+	started := time.Now()
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	// The first 64KB pass at once, the rest at 64KB per second
+	if took := time.Since(started); took < 400*time.Millisecond {
+		t.Errorf("Expected the body to be throttled, upload took %v", took)
+	}
+}
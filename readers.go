@@ -0,0 +1,75 @@
+package chunkeduploader
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// contextReader fails reads with the context's error once ctx is done, so
+// long copies stop promptly on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// contextReadCloser reads through a contextReader and closes the original body.
+type contextReadCloser struct {
+	contextReader
+	io.Closer
+}
+
+// countingReader counts the bytes read through it and calls onRead with the
+// running total.
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	onRead func(total int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.n += int64(n)
+		if c.onRead != nil {
+			c.onRead(c.n)
+		}
+	}
+	return n, err
+}
+
+// throttleChunk bounds how much a throttled reader reads before waiting.
+const throttleChunk = 32 << 10
+
+// throttledReader limits reads to the rate of its bucket.
+type throttledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if wait := t.bucket.reserve(float64(n)); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return n, err
+}
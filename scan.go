@@ -0,0 +1,127 @@
+package chunkeduploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Verdict is the outcome of a malware scan.
+type Verdict string
+
+const (
+	VerdictClean    Verdict = "clean"
+	VerdictInfected Verdict = "infected"
+	VerdictError    Verdict = "error"
+)
+
+// ScanResult describes a scanned file.
+type ScanResult struct {
+	Verdict   Verdict `json:"verdict"`
+	Signature string  `json:"signature,omitempty"` // the malware found, for infected files
+
+	// Modified reports that the scanner replaced the file's content, for
+	// example to redact it; the verdict is then VerdictClean.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Scanner checks assembled files for malware before they are published. It
+// returns an error, with VerdictError, when the file could not be scanned.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (ScanResult, error)
+}
+
+var (
+	// ErrInfected is matched by the ScanError of an infected upload.
+	ErrInfected = errors.New("file is infected")
+	// ErrScanFailed is matched by the ScanError of an upload that could not
+	// be scanned.
+	ErrScanFailed = errors.New("malware scan failed")
+)
+
+// ScanError is returned for uploads that did not scan clean. The file has
+// been moved to QuarantinePath.
+type ScanError struct {
+	Result         ScanResult
+	QuarantinePath string
+	Err            error // the scanner's error, for VerdictError
+}
+
+func (e *ScanError) Error() string {
+	if e.Result.Verdict == VerdictInfected {
+		return fmt.Sprintf("%v: %s, quarantined at %s", ErrInfected, e.Result.Signature, e.QuarantinePath)
+	}
+	return fmt.Sprintf("%v: %v, quarantined at %s", ErrScanFailed, e.Err, e.QuarantinePath)
+}
+
+func (e *ScanError) Unwrap() []error {
+	if e.Result.Verdict == VerdictInfected {
+		return []error{ErrInfected}
+	}
+	return []error{ErrScanFailed, e.Err}
+}
+
+// scan runs the configured scanner over an assembled file while it still has
+// its staging name. Files that do not scan clean are moved to the quarantine
+// directory and reported as a *ScanError; the verdict of clean files is added
+// to metadata.
+func (u *Uploader) scan(ctx context.Context, metadata map[string]interface{}) error {
+	if u.config.Scanner == nil {
+		return nil
+	}
+
+	path := metadata["path"].(string)
+	ctx, span := u.tracer.Start(ctx, "chunkeduploader.Scan")
+	result, err := u.config.Scanner.Scan(ctx, path)
+	endSpan(span, err)
+	if err == nil && result.Verdict == VerdictClean {
+		metadata["scanVerdict"] = string(VerdictClean)
+		if result.Modified {
+			info, statErr := os.Stat(path)
+			if statErr != nil {
+				os.Remove(path)
+				return fmt.Errorf("error reading modified file: %v", statErr)
+			}
+			metadata["fileSize"] = info.Size()
+			metadata["scanModified"] = true
+		}
+		return nil
+	}
+	// A scan cut short by shutdown or cancellation has no verdict; the file
+	// is dropped rather than quarantined, as the chunks may be kept for a retry
+	if err != nil && ctx.Err() != nil {
+		os.Remove(path)
+		return fmt.Errorf("scan cancelled: %w", ctx.Err())
+	}
+	if err != nil || result.Verdict != VerdictInfected {
+		if err == nil {
+			err = fmt.Errorf("unexpected verdict %q", result.Verdict)
+		}
+		result.Verdict = VerdictError
+	}
+
+	scanErr := &ScanError{Result: result, Err: err}
+	quarantinePath, moveErr := u.quarantine(path, metadata["storedName"].(string))
+	if moveErr != nil {
+		// Never leave an unscanned or infected file where it could be served
+		os.Remove(path)
+		return fmt.Errorf("%w (quarantine failed, file deleted: %v)", scanErr, moveErr)
+	}
+	scanErr.QuarantinePath = quarantinePath
+	return scanErr
+}
+
+// quarantine moves the file at path into Config.QuarantineDir, under its
+// stored name.
+func (u *Uploader) quarantine(path, storedName string) (string, error) {
+	if err := os.MkdirAll(u.config.QuarantineDir, 0700); err != nil {
+		return "", fmt.Errorf("error creating quarantine directory: %v", err)
+	}
+	target := filepath.Join(u.config.QuarantineDir, storedName)
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("error quarantining file: %v", err)
+	}
+	return target, nil
+}
@@ -0,0 +1,237 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// startFakeClamd serves the INSTREAM command like clamd, reporting streams
+// that contain the EICAR test string as infected.
+func startFakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var stream bytes.Buffer
+				for {
+					var length [4]byte
+					if _, err := io.ReadFull(r, length[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(length[:])
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&stream, r, int64(n)); err != nil {
+						return
+					}
+				}
+
+				if bytes.Contains(stream.Bytes(), []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	address := startFakeClamd(t)
+	dir := t.TempDir()
+	scanner := &ClamdScanner{Address: address, ChunkSize: 16}
+
+	clean := filepath.Join(dir, "clean.txt")
+	os.WriteFile(clean, []byte("Hello, World! This spans several INSTREAM chunks."), 0644)
+	if result, err := scanner.Scan(context.Background(), clean); err != nil || result.Verdict != VerdictClean {
+		t.Errorf("Expected clean verdict, got %+v, %v", result, err)
+	}
+
+	infected := filepath.Join(dir, "eicar.com")
+	os.WriteFile(infected, []byte(eicar), 0644)
+	result, err := scanner.Scan(context.Background(), infected)
+	if err != nil || result.Verdict != VerdictInfected || result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected infected verdict with signature, got %+v, %v", result, err)
+	}
+
+	unreachable := &ClamdScanner{Address: "127.0.0.1:1"}
+	if result, err := unreachable.Scan(context.Background(), clean); err == nil || result.Verdict != VerdictError {
+		t.Errorf("Expected error verdict for unreachable daemon, got %+v, %v", result, err)
+	}
+}
+
+func TestUploader_QuarantinesInfectedFiles(t *testing.T) {
+	dir := t.TempDir()
+	events := NewChannelSink(4)
+	u := NewUploader(Config{
+		TempDir:       filepath.Join(dir, "chunks"),
+		UploadDir:     filepath.Join(dir, "uploads"),
+		QuarantineDir: filepath.Join(dir, "quarantine"),
+		Scanner:       &ClamdScanner{Address: startFakeClamd(t)},
+		EventSink:     events,
+	})
+
+	upload := func(fileName string, data string) (map[string]interface{}, error) {
+		req, _ := createUploadForm(map[string]string{
+			"fileName":    fileName,
+			"chunkIndex":  "0",
+			"totalChunks": "1",
+			"fileSize":    fmt.Sprint(len(data)),
+		}, []byte(data))
+		return u.Handle(req)
+	}
+
+	result, err := upload("clean.txt", "Hello")
+	if err != nil {
+		t.Fatalf("Clean upload failed: %v", err)
+	}
+	if verdict := result["metadata"].(map[string]interface{})["scanVerdict"]; verdict != "clean" {
+		t.Errorf("Expected clean verdict in metadata, got %v", verdict)
+	}
+	<-events.C
+
+	_, err = upload("eicar.com", eicar)
+	var scanErr *ScanError
+	if !errors.Is(err, ErrInfected) || !errors.As(err, &scanErr) {
+		t.Fatalf("Expected infected upload to fail with a ScanError, got %v", err)
+	}
+	if scanErr.Result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected signature in result, got %+v", scanErr.Result)
+	}
+	if content, err := os.ReadFile(scanErr.QuarantinePath); err != nil || string(content) != eicar {
+		t.Errorf("Expected file in quarantine, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 1 {
+		t.Errorf("Infected file should not stay in the upload directory, found %d files", len(entries))
+	}
+
+	event := <-events.C
+	if event.Type != EventFailed || event.Metadata["scanVerdict"] != "infected" {
+		t.Errorf("Expected failed event with the verdict, got %+v", event)
+	}
+}
+
+type scannerFunc func(ctx context.Context, path string) (ScanResult, error)
+
+func (f scannerFunc) Scan(ctx context.Context, path string) (ScanResult, error) {
+	return f(ctx, path)
+}
+
+func TestUploader_ScansBeforePublishing(t *testing.T) {
+	dir := t.TempDir()
+	uploads := filepath.Join(dir, "uploads")
+	var visible []string
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: uploads,
+		Scanner: scannerFunc(func(ctx context.Context, path string) (ScanResult, error) {
+			entries, _ := os.ReadDir(uploads)
+			for _, entry := range entries {
+				if !strings.HasPrefix(entry.Name(), ".") {
+					visible = append(visible, entry.Name())
+				}
+			}
+			return ScanResult{Verdict: VerdictClean}, nil
+		}),
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    "report.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	result, err := u.Handle(req)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if len(visible) != 0 {
+		t.Errorf("Expected no published file during the scan, found %v", visible)
+	}
+	metadata := result["metadata"].(map[string]interface{})
+	path := metadata["path"].(string)
+	if path != filepath.Join(uploads, metadata["storedName"].(string)) {
+		t.Errorf("Expected the file at its stored name, got %s", path)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "Hello" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if entries, _ := os.ReadDir(uploads); len(entries) != 1 {
+		t.Errorf("Expected only the published file, found %d files", len(entries))
+	}
+}
+
+func TestUploader_ShutdownDuringScan(t *testing.T) {
+	dir := t.TempDir()
+	quarantine := filepath.Join(dir, "quarantine")
+	entered := make(chan struct{})
+	u := NewUploader(Config{
+		TempDir:       filepath.Join(dir, "chunks"),
+		UploadDir:     filepath.Join(dir, "uploads"),
+		QuarantineDir: quarantine,
+		Scanner: scannerFunc(func(ctx context.Context, path string) (ScanResult, error) {
+			close(entered)
+			<-ctx.Done()
+			return ScanResult{}, ctx.Err()
+		}),
+	})
+
+	handled := make(chan error, 1)
+	go func() {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "scanning",
+			"fileName":    "report.txt",
+			"chunkIndex":  "0",
+			"totalChunks": "1",
+			"fileSize":    "5",
+		}, []byte("Hello"))
+		_, err := u.Handle(req)
+		handled <- err
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	u.Shutdown(ctx)
+	if err := <-handled; !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected the interrupted scan to report ErrShuttingDown, got %v", err)
+	}
+	if entries, _ := os.ReadDir(quarantine); len(entries) != 0 {
+		t.Errorf("Interrupted scans should not quarantine, found %d files", len(entries))
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 0 {
+		t.Errorf("Partial output should be removed, found %d files", len(entries))
+	}
+}
@@ -0,0 +1,145 @@
+package chunkeduploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// beginOperation registers an in-flight chunk request. It reports false once
+// the uploader is shutting down.
+func (u *Uploader) beginOperation() bool {
+	u.lifecycle.Lock()
+	defer u.lifecycle.Unlock()
+
+	if u.closing {
+		return false
+	}
+	u.inflight.Add(1)
+	return true
+}
+
+// Shutdown stops accepting chunks and waits for in-flight chunk writes and
+// assemblies to finish. If ctx ends first, the remaining operations are
+// cancelled and rolled back: partially written chunks and files are removed
+// and interrupted assemblies keep their chunks. Unfinished sessions are then
+// saved to Config.StateFile, if set, so a new Uploader can resume them.
+func (u *Uploader) Shutdown(ctx context.Context) error {
+	u.lifecycle.Lock()
+	u.closing = true
+	u.lifecycle.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		u.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		u.stop()
+		<-drained
+	}
+
+	if u.config.StateFile != "" {
+		if saveErr := u.files.saveState(u.config.StateFile); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// persistedSession is the saved form of a session that was still receiving.
+type persistedSession struct {
+	Key       string           `json:"key"`
+	Info      SessionInfo      `json:"info"`
+	Chunks    []persistedChunk `json:"chunks"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+type persistedChunk struct {
+	Path        string `json:"path,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// saveState writes all receiving sessions to path, replacing it atomically.
+func (fm *FileManager) saveState(path string) error {
+	fm.mutex.RLock()
+	var sessions []persistedSession
+	for key, s := range fm.sessions {
+		if s.state != StateReceiving {
+			continue
+		}
+		saved := persistedSession{Key: key, Info: s.info, UpdatedAt: s.updatedAt}
+		for _, chunk := range s.chunks {
+			saved.Chunks = append(saved.Chunks, persistedChunk{Path: chunk.path, Size: chunk.size, Digest: chunk.digest, ContentType: chunk.contentType})
+		}
+		sessions = append(sessions, saved)
+	}
+	fm.mutex.RUnlock()
+
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("error encoding session state: %v", err)
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing session state: %v", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error writing session state: %v", err)
+	}
+	return nil
+}
+
+// loadState restores sessions saved by saveState. Chunks whose files have
+// disappeared are dropped so that clients upload them again. A missing
+// state file is not an error.
+func (fm *FileManager) loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading session state: %v", err)
+	}
+
+	var sessions []persistedSession
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return fmt.Errorf("error decoding session state: %v", err)
+	}
+	// The sessions now live in memory; a stale file must not resurrect them
+	// after a later crash.
+	os.Remove(path)
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	for _, saved := range sessions {
+		if _, exists := fm.sessions[saved.Key]; exists {
+			continue
+		}
+
+		s := fm.sessionLocked(saved.Key, saved.Info)
+		s.updatedAt = saved.UpdatedAt
+		for i, chunk := range saved.Chunks {
+			if i >= len(s.chunks) || chunk.Path == "" {
+				continue
+			}
+			if _, err := os.Stat(chunk.Path); err != nil {
+				continue
+			}
+			s.chunks[i] = chunkRecord{path: chunk.Path, size: chunk.Size, digest: chunk.Digest, contentType: chunk.ContentType}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrTypeNotAllowed is returned for uploads whose content type is refused by
+// the TypePolicy.
+var ErrTypeNotAllowed = errors.New("file type not allowed")
+
+// sniffLen is how much of the first chunk is inspected, as in http.DetectContentType.
+const sniffLen = 512
+
+// TypePolicy decides which files may be uploaded, by the content type detected
+// from the magic bytes of the first chunk rather than the client's extension.
+// Types are media types such as "image/png", or wildcards such as "image/*".
+// The first chunk is rejected if the policy refuses it.
+type TypePolicy struct {
+	Allow []string // detected types accepted, all if empty
+	Deny  []string // detected types refused, even if allowed
+
+	// RejectExtensionMismatch refuses files whose content contradicts the type
+	// implied by their extension, such as an executable named photo.png.
+	RejectExtensionMismatch bool
+}
+
+// check applies the policy to a file with the given name and detected type.
+func (p TypePolicy) check(fileName string, detected string) error {
+	if len(p.Allow) > 0 && !matchContentType(p.Allow, detected) {
+		return fmt.Errorf("%w: %s", ErrTypeNotAllowed, detected)
+	}
+	if len(p.Deny) > 0 && matchContentType(p.Deny, detected) {
+		return fmt.Errorf("%w: %s", ErrTypeNotAllowed, detected)
+	}
+	if p.RejectExtensionMismatch {
+		declared := declaredType(fileName)
+		if !extensionMatches(declared, detected) {
+			return fmt.Errorf("%w: content is %s but the extension implies %s", ErrTypeNotAllowed, detected, declared)
+		}
+	}
+	return nil
+}
+
+// declaredType is the media type implied by a file name's extension, or ""
+// if the extension is unknown.
+func declaredType(fileName string) string {
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// sniffFile detects the content type of the file at path from its first bytes.
+func sniffFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading chunk: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("error reading chunk: %v", err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return mediaType, nil
+}
+
+// sniffableTypes are the media types http.DetectContentType recognizes.
+// Content declared as one of them must be detected as it.
+var sniffableTypes = map[string]bool{
+	"application/pdf": true, "application/postscript": true, "application/ogg": true,
+	"application/zip": true, "application/x-gzip": true, "application/gzip": true,
+	"application/x-rar-compressed": true, "application/x-7z-compressed": true, "application/wasm": true,
+	"application/vnd.ms-fontobject": true, "font/ttf": true, "font/otf": true, "font/collection": true,
+	"font/woff": true, "font/woff2": true,
+	"image/bmp": true, "image/gif": true, "image/jpeg": true, "image/png": true, "image/webp": true,
+	"image/x-icon": true, "image/vnd.microsoft.icon": true,
+	"audio/aiff": true, "audio/mpeg": true, "audio/wave": true, "audio/wav": true, "audio/x-wav": true,
+	"audio/basic": true, "audio/midi": true,
+	"video/avi": true, "video/x-msvideo": true, "video/mp4": true, "video/webm": true,
+}
+
+// extensionMatches reports whether content detected as detected is consistent
+// with the declared type. Formats the sniffer cannot recognize are accepted.
+func extensionMatches(declared, detected string) bool {
+	switch {
+	case declared == "" || declared == detected:
+		return true
+	case zipContainer(declared):
+		return detected == "application/zip"
+	case textual(declared):
+		return strings.HasPrefix(detected, "text/")
+	case declared == "image/x-icon" || declared == "image/vnd.microsoft.icon":
+		return detected == "image/x-icon" || detected == "image/vnd.microsoft.icon"
+	}
+	return !sniffableTypes[declared]
+}
+
+// zipContainer reports whether files of the media type are ZIP archives.
+func zipContainer(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument.") ||
+		mediaType == "application/epub+zip" || mediaType == "application/java-archive" ||
+		mediaType == "application/vnd.android.package-archive"
+}
+
+// textual reports whether files of the media type are plain text.
+func textual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/json" || mediaType == "application/xml" || mediaType == "application/javascript"
+}
@@ -0,0 +1,98 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestTypePolicy_DetectsContentType(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    "report.pdf",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	result, err := u.Handle(req)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	metadata := result["metadata"].(map[string]interface{})
+	if metadata["mimeType"] != "application/pdf" || metadata["detectedMimeType"] != "text/plain" {
+		t.Errorf("Expected declared and detected types, got %v and %v", metadata["mimeType"], metadata["detectedMimeType"])
+	}
+}
+
+func TestTypePolicy_RejectsAtFirstChunk(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		TypePolicy: TypePolicy{
+			Allow:                   []string{"image/*", "application/octet-stream"},
+			Deny:                    []string{"image/gif"},
+			RejectExtensionMismatch: true,
+		},
+	})
+
+	send := func(uploadID, fileName string, data []byte) *httptest.ResponseRecorder {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    uploadID,
+			"fileName":    fileName,
+			"chunkIndex":  "0",
+			"totalChunks": "2",
+			"fileSize":    "1000",
+		}, data)
+		rec := httptest.NewRecorder()
+		u.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("png", "photo.png", pngHeader); rec.Code != http.StatusOK {
+		t.Errorf("Expected PNG to be accepted, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send("renamed-exe", "photo.png", []byte("MZ\x90\x00\x03\x00\x00\x00")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected executable named .png to be rejected, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send("gif", "anim.gif", []byte("GIF89a\x01\x00\x01\x00")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected denied type to be rejected, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send("text", "notes.txt", []byte("plain text")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected type outside the allow list to be rejected, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := u.Progress("renamed-exe"); ok {
+		t.Error("A rejected first chunk should not start a session")
+	}
+}
+
+func TestTypePolicy_ExtensionMatches(t *testing.T) {
+	if extensionMatches("image/png", "application/octet-stream") {
+		t.Error("Unrecognized content should not pass as a PNG")
+	}
+	if !extensionMatches("application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip") {
+		t.Error("Office documents are ZIP archives")
+	}
+	if !extensionMatches("application/json", "text/plain") {
+		t.Error("JSON is detected as plain text")
+	}
+	if !extensionMatches("application/x-msdownload", "application/octet-stream") {
+		t.Error("Formats the sniffer cannot recognize should be accepted")
+	}
+	if !extensionMatches("", "image/png") {
+		t.Error("Files without a known extension should be accepted")
+	}
+	if err := (TypePolicy{Deny: []string{"text/*"}}).check("a.txt", "text/plain"); !errors.Is(err, ErrTypeNotAllowed) {
+		t.Errorf("Expected ErrTypeNotAllowed, got %v", err)
+	}
+}
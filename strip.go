@@ -0,0 +1,229 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Kinds of embedded metadata MetadataStripper recognizes.
+const (
+	MetadataEXIF    = "exif"    // camera settings, GPS coordinates
+	MetadataXMP     = "xmp"     // Adobe XMP packets
+	MetadataICC     = "icc"     // color profiles
+	MetadataIPTC    = "iptc"    // Photoshop/IPTC records
+	MetadataComment = "comment" // JPEG comments and PNG text chunks
+)
+
+// MetadataStripper is a Processor that removes embedded metadata from JPEG
+// and PNG uploads without re-encoding them. The sanitized file replaces the
+// stored one; the kinds removed are recorded under "strippedMetadata". Other
+// files are left alone.
+//
+// Stripping EXIF also drops the orientation tag, so photos taken in portrait
+// may display rotated.
+type MetadataStripper struct {
+	Keep []string // kinds of metadata to preserve, for example MetadataICC
+}
+
+// Process implements Processor.
+func (s *MetadataStripper) Process(ctx context.Context, file *ProcessedFile) error {
+	in, err := os.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	magic, _ := r.Peek(8)
+	var strip func(*bufio.Reader, io.Writer) ([]string, error)
+	switch {
+	case bytes.HasPrefix(magic, []byte("\xff\xd8")):
+		strip = s.stripJPEG
+	case bytes.Equal(magic, []byte(pngSignature)):
+		strip = s.stripPNG
+	default:
+		return nil
+	}
+
+	temp := file.Path + ".strip"
+	out, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("error creating sanitized file: %v", err)
+	}
+	w := bufio.NewWriter(out)
+	removed, err := strip(r, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || len(removed) == 0 {
+		os.Remove(temp)
+		if err != nil {
+			return fmt.Errorf("error stripping metadata: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.Rename(temp, file.Path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error replacing file: %v", err)
+	}
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return fmt.Errorf("error replacing file: %v", err)
+	}
+	file.Metadata["fileSize"] = info.Size()
+	file.Metadata["strippedMetadata"] = removed
+	return nil
+}
+
+// remove reports whether metadata of the given kind should be stripped, and
+// adds it to removed if so.
+func (s *MetadataStripper) remove(kind string, removed *[]string) bool {
+	if slices.Contains(s.Keep, kind) {
+		return false
+	}
+	if !slices.Contains(*removed, kind) {
+		*removed = append(*removed, kind)
+	}
+	return true
+}
+
+var errMalformedImage = errors.New("malformed image")
+
+// stripJPEG copies a JPEG, leaving out metadata segments. Everything from the
+// start of the scan data is copied unchanged.
+func (s *MetadataStripper) stripJPEG(r *bufio.Reader, w io.Writer) ([]string, error) {
+	var removed []string
+	soi := make([]byte, 2)
+	io.ReadFull(r, soi)
+	w.Write(soi)
+
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, errMalformedImage
+		}
+		if marker != 0xff {
+			return nil, errMalformedImage
+		}
+		code, err := r.ReadByte()
+		for err == nil && code == 0xff { // fill bytes
+			code, err = r.ReadByte()
+		}
+		if err != nil {
+			return nil, errMalformedImage
+		}
+		// Markers without a length
+		if code == 0x01 || (code >= 0xd0 && code <= 0xd7) {
+			w.Write([]byte{0xff, code})
+			continue
+		}
+		if code == 0xd9 {
+			w.Write([]byte{0xff, code})
+			return removed, nil
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return nil, errMalformedImage
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, errMalformedImage
+		}
+
+		if kind := jpegSegmentKind(code, segment); kind == "" || !s.remove(kind, &removed) {
+			w.Write([]byte{0xff, code})
+			binary.Write(w, binary.BigEndian, length)
+			w.Write(segment)
+		}
+
+		if code == 0xda { // start of scan
+			_, err := io.Copy(w, r)
+			return removed, err
+		}
+	}
+}
+
+// jpegSegmentKind classifies a JPEG segment as metadata, or returns "".
+func jpegSegmentKind(code byte, segment []byte) string {
+	switch {
+	case code == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00")):
+		return MetadataEXIF
+	case code == 0xe1 && (bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xap/1.0/\x00")) ||
+		bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xmp/extension/\x00"))):
+		return MetadataXMP
+	case code == 0xe2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+		return MetadataICC
+	case code == 0xed && bytes.HasPrefix(segment, []byte("Photoshop 3.0\x00")):
+		return MetadataIPTC
+	case code == 0xfe:
+		return MetadataComment
+	}
+	return ""
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// stripPNG copies a PNG chunk by chunk, leaving out metadata chunks.
+func (s *MetadataStripper) stripPNG(r *bufio.Reader, w io.Writer) ([]string, error) {
+	var removed []string
+	signature := make([]byte, len(pngSignature))
+	io.ReadFull(r, signature)
+	w.Write(signature)
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, errMalformedImage
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:])
+		if length > 1<<31-1 {
+			return nil, errMalformedImage
+		}
+
+		kind := ""
+		switch chunkType {
+		case "eXIf":
+			kind = MetadataEXIF
+		case "iCCP":
+			kind = MetadataICC
+		case "tEXt", "zTXt", "iTXt":
+			// Text chunks start with their keyword; XMP uses a well-known one
+			keyword, err := r.Peek(min(int(length), 80))
+			if err != nil {
+				return nil, errMalformedImage
+			}
+			kind = MetadataComment
+			if chunkType == "iTXt" && bytes.HasPrefix(keyword, []byte("XML:com.adobe.xmp\x00")) {
+				kind = MetadataXMP
+			}
+		}
+
+		if kind != "" && s.remove(kind, &removed) {
+			if _, err := r.Discard(int(length) + 4); err != nil {
+				return nil, errMalformedImage
+			}
+			continue
+		}
+
+		w.Write(header[:])
+		if _, err := io.CopyN(w, r, int64(length)+4); err != nil { // data and CRC
+			return nil, errMalformedImage
+		}
+		if chunkType == "IEND" {
+			return removed, nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// jpegWithMetadata encodes a small JPEG and inserts EXIF, ICC and comment
+// segments after its start marker.
+func jpegWithMetadata(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	segment := func(code byte, payload string) []byte {
+		s := []byte{0xff, code, 0, 0}
+		binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+		return append(s, payload...)
+	}
+	encoded := buf.Bytes()
+	var out bytes.Buffer
+	out.Write(encoded[:2])
+	out.Write(segment(0xe1, "Exif\x00\x00GPSLatitude=51.5"))
+	out.Write(segment(0xe2, "ICC_PROFILE\x00\x01\x01profile"))
+	out.Write(segment(0xfe, "taken at home"))
+	out.Write(encoded[2:])
+	return out.Bytes()
+}
+
+// pngWithMetadata inserts text, EXIF and XMP chunks after the IHDR chunk of a PNG.
+func pngWithMetadata(t *testing.T) []byte {
+	encoded := encodePNG(t, 8, 8)
+	chunk := func(chunkType, data string) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		c = append(c, chunkType+data...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE([]byte(chunkType+data)))
+	}
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	var out bytes.Buffer
+	out.Write(encoded[:ihdrEnd])
+	out.Write(chunk("tEXt", "Author\x00Jane"))
+	out.Write(chunk("eXIf", "MM\x00\x2aGPS"))
+	out.Write(chunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+	out.Write(encoded[ihdrEnd:])
+	return out.Bytes()
+}
+
+func TestMetadataStripper_JPEG(t *testing.T) {
+	dir := t.TempDir()
+	original := jpegWithMetadata(t)
+
+	path := filepath.Join(dir, "photo.jpg")
+	os.WriteFile(path, original, 0644)
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	if err := (&MetadataStripper{}).Process(context.Background(), file); err != nil {
+		t.Fatalf("Stripping failed: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	for _, leaked := range []string{"GPSLatitude", "ICC_PROFILE", "taken at home"} {
+		if bytes.Contains(content, []byte(leaked)) {
+			t.Errorf("Expected %q to be stripped", leaked)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(content)); err != nil {
+		t.Errorf("Sanitized JPEG does not decode: %v", err)
+	}
+	removed := file.Metadata["strippedMetadata"].([]string)
+	if !slices.Equal(removed, []string{MetadataEXIF, MetadataICC, MetadataComment}) {
+		t.Errorf("Expected removed kinds in metadata, got %v", removed)
+	}
+	if file.Metadata["fileSize"] != int64(len(content)) {
+		t.Errorf("Expected updated fileSize, got %v", file.Metadata["fileSize"])
+	}
+
+	kept := filepath.Join(dir, "kept.jpg")
+	os.WriteFile(kept, original, 0644)
+	file = &ProcessedFile{Path: kept, Metadata: map[string]interface{}{}}
+	(&MetadataStripper{Keep: []string{MetadataICC}}).Process(context.Background(), file)
+	content, _ = os.ReadFile(kept)
+	if !bytes.Contains(content, []byte("ICC_PROFILE")) || bytes.Contains(content, []byte("GPSLatitude")) {
+		t.Errorf("Expected only the whitelisted ICC profile to remain")
+	}
+}
+
+func TestMetadataStripper_PNG(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	os.WriteFile(path, pngWithMetadata(t), 0644)
+	file := &ProcessedFile{Path: path, Metadata: map[string]interface{}{}}
+	if err := (&MetadataStripper{}).Process(context.Background(), file); err != nil {
+		t.Fatalf("Stripping failed: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if !bytes.Equal(content, encodePNG(t, 8, 8)) {
+		t.Errorf("Expected the PNG without its metadata chunks")
+	}
+	removed := file.Metadata["strippedMetadata"].([]string)
+	if !slices.Equal(removed, []string{MetadataComment, MetadataEXIF, MetadataXMP}) {
+		t.Errorf("Expected removed kinds in metadata, got %v", removed)
+	}
+
+	clean := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(clean, []byte("Exif"), 0644)
+	file = &ProcessedFile{Path: clean, Metadata: map[string]interface{}{}}
+	if err := (&MetadataStripper{}).Process(context.Background(), file); err != nil || len(file.Metadata) != 0 {
+		t.Errorf("Expected other files to be left alone, got %v, %v", file.Metadata, err)
+	}
+}
+
+func TestUploader_StripsMetadata(t *testing.T) {
+	u, _, _ := processingUploader(t, ProcessingStep{Name: "strip", Processor: &MetadataStripper{}, Required: true})
+	original := jpegWithMetadata(t)
+	result, err := uploadSingleChunk(u, "photo.jpg", string(original))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	metadata := result["metadata"].(map[string]interface{})
+	content, _ := os.ReadFile(metadata["path"].(string))
+	if bytes.Contains(content, []byte("GPSLatitude")) || metadata["fileSize"] != int64(len(content)) || len(content) >= len(original) {
+		t.Errorf("Expected the stored file to be sanitized, got %d bytes, metadata %v", len(content), metadata)
+	}
+}
@@ -0,0 +1,190 @@
+package chunkeduploader
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for chunks whose upload token is missing,
+	// malformed, badly signed or violated by the upload.
+	ErrInvalidToken = errors.New("invalid upload token")
+	// ErrTokenExpired is returned for chunks sent with an expired upload token.
+	ErrTokenExpired = errors.New("upload token expired")
+)
+
+// UploadClaims are the constraints an upload token grants. Zero values leave
+// the corresponding property unrestricted, except ExpiresAt, which is required.
+type UploadClaims struct {
+	UploadID     string    `json:"uploadId,omitempty"`     // the only upload the token may be used for
+	FileName     string    `json:"fileName,omitempty"`     // the only file name allowed
+	MaxSize      int64     `json:"maxSize,omitempty"`      // largest allowed file size in bytes
+	ContentTypes []string  `json:"contentTypes,omitempty"` // allowed MIME types, "image/*" style wildcards accepted
+	Prefix       string    `json:"prefix,omitempty"`       // directory under UploadDir the file is stored in
+	Owner        string    `json:"owner,omitempty"`        // attached to the session and its metadata
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// NewUploadToken signs claims with secret. The token is the base64url JSON
+// claims and the base64url HMAC-SHA256 of that string, joined by a dot.
+// Application backends mint tokens and hand them to the clients they trust.
+func NewUploadToken(secret []byte, claims UploadClaims) (string, error) {
+	if claims.ExpiresAt.IsZero() {
+		return "", fmt.Errorf("upload token needs an expiry")
+	}
+	if claims.Prefix != "" && !validPrefix(claims.Prefix) {
+		return "", fmt.Errorf("invalid prefix %q", claims.Prefix)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("error encoding upload token: %v", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signToken(secret, encoded), nil
+}
+
+// ParseUploadToken verifies token against secret and returns its claims.
+func ParseUploadToken(secret []byte, token string, now time.Time) (UploadClaims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signToken(secret, encoded))) {
+		return UploadClaims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return UploadClaims{}, ErrInvalidToken
+	}
+	var claims UploadClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return UploadClaims{}, ErrInvalidToken
+	}
+	if claims.ExpiresAt.IsZero() || (claims.Prefix != "" && !validPrefix(claims.Prefix)) {
+		return UploadClaims{}, ErrInvalidToken
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return UploadClaims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func signToken(secret []byte, encoded string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validPrefix accepts relative slash-separated paths that stay below the
+// upload directory.
+func validPrefix(prefix string) bool {
+	if strings.Contains(prefix, `\`) || path.IsAbs(prefix) || filepath.IsAbs(prefix) {
+		return false
+	}
+	clean := path.Clean(prefix)
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// requestToken returns the upload token sent as a bearer token, or in the
+// uploadToken form field.
+func requestToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	return r.FormValue("uploadToken")
+}
+
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// hasCredentialsOutsideBody reports whether the request can be authorized
+// before its body is read.
+func hasCredentialsOutsideBody(r *http.Request) bool {
+	return r.URL.Query().Has(presignSignature) || bearerToken(r) != ""
+}
+
+// authorize verifies the request's upload token, or the signature of its
+// pre-signed URL, when Config.TokenSecret is set, and returns its claims.
+func (u *Uploader) authorize(r *http.Request) (UploadClaims, error) {
+	if len(u.config.TokenSecret) == 0 {
+		return UploadClaims{}, nil
+	}
+	if query := r.URL.Query(); query.Has(presignSignature) {
+		return parsePresignedQuery(u.config.TokenSecret, query, time.Now())
+	}
+	token := requestToken(r)
+	if token == "" {
+		return UploadClaims{}, fmt.Errorf("%w: token required", ErrInvalidToken)
+	}
+	return ParseUploadToken(u.config.TokenSecret, token, time.Now())
+}
+
+// checkClaims reports whether the upload described by info is allowed by claims.
+func checkClaims(claims UploadClaims, info SessionInfo) error {
+	if claims.UploadID != "" && claims.UploadID != info.UploadID {
+		return fmt.Errorf("%w: token is for another upload", ErrInvalidToken)
+	}
+	if claims.FileName != "" && claims.FileName != info.FileName {
+		return fmt.Errorf("%w: token is for another file", ErrInvalidToken)
+	}
+	if claims.MaxSize > 0 && info.FileSize > claims.MaxSize {
+		return fmt.Errorf("%w: file size %d exceeds %d", ErrInvalidToken, info.FileSize, claims.MaxSize)
+	}
+	if len(claims.ContentTypes) > 0 {
+		contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(info.FileName)))
+		if !matchContentType(claims.ContentTypes, contentType) {
+			return fmt.Errorf("%w: content type %q not allowed", ErrInvalidToken, contentType)
+		}
+	}
+	return nil
+}
+
+// checkDetectedType enforces the token's content types on the type detected
+// from the first chunk, so a file cannot pass as an allowed type by its name
+// alone. Content the sniffer describes only generically, such as a CSV file
+// detected as text/plain, is accepted if it confirms the type the file name
+// implies. Content the sniffer cannot recognize at all is detected as
+// application/octet-stream and needs the token to allow that type.
+func checkDetectedType(claims UploadClaims, fileName string, detected string) error {
+	if len(claims.ContentTypes) == 0 || matchContentType(claims.ContentTypes, detected) {
+		return nil
+	}
+	declared := declaredType(fileName)
+	confirmable := sniffableTypes[declared] || zipContainer(declared) || textual(declared)
+	if confirmable && matchContentType(claims.ContentTypes, declared) && extensionMatches(declared, detected) {
+		return nil
+	}
+	return fmt.Errorf("%w: detected content type %q not allowed", ErrInvalidToken, detected)
+}
+
+// matchContentType reports whether contentType, without parameters, matches
+// one of allowed.
+func matchContentType(allowed []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+		if base, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, base+"/") {
+			return true
+		}
+	}
+	return false
+}
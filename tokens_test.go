@@ -0,0 +1,188 @@
+package chunkeduploader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadToken_RoundTrip(t *testing.T) {
+	secret := []byte("token-secret")
+	claims := UploadClaims{UploadID: "u1", Owner: "user-42", MaxSize: 100, ExpiresAt: time.Now().Add(time.Minute)}
+
+	token, err := NewUploadToken(secret, claims)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	parsed, err := ParseUploadToken(secret, token, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsed.Owner != "user-42" || parsed.MaxSize != 100 || !parsed.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("Expected claims to round-trip, got %+v", parsed)
+	}
+
+	if _, err := ParseUploadToken([]byte("other-secret"), token, time.Now()); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for wrong secret, got %v", err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	tampered := strings.ToUpper(payload[:1]) + strings.ToLower(payload[1:]) + "." + signature
+	if _, err := ParseUploadToken(secret, tampered, time.Now()); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for tampered token, got %v", err)
+	}
+	if _, err := ParseUploadToken(secret, token, time.Now().Add(time.Hour)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+	if _, err := NewUploadToken(secret, UploadClaims{Prefix: "../escape", ExpiresAt: claims.ExpiresAt}); err == nil {
+		t.Error("Expected error for a prefix outside the upload directory")
+	}
+}
+
+func TestUploader_RequiresValidToken(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("token-secret")
+	u := NewUploader(Config{
+		TempDir:     filepath.Join(dir, "chunks"),
+		UploadDir:   filepath.Join(dir, "uploads"),
+		TokenSecret: secret,
+	})
+
+	mint := func(claims UploadClaims) string {
+		claims.ExpiresAt = time.Now().Add(time.Minute)
+		token, err := NewUploadToken(secret, claims)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token
+	}
+	upload := func(token string, fileName string, index string) (map[string]interface{}, error) {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "tokened",
+			"fileName":    fileName,
+			"chunkIndex":  index,
+			"totalChunks": "2",
+			"fileSize":    "13",
+		}, []byte(map[string]string{"0": "%PDF-1.", "1": "7 data"}[index]))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return u.Handle(req)
+	}
+
+	if _, err := upload("", "report.pdf", "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token to be required, got %v", err)
+	}
+	if _, err := upload(mint(UploadClaims{MaxSize: 10}), "report.pdf", "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected size limit to be enforced, got %v", err)
+	}
+	if _, err := upload(mint(UploadClaims{ContentTypes: []string{"image/*"}}), "report.pdf", "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected content type to be enforced, got %v", err)
+	}
+	if _, err := upload(mint(UploadClaims{ContentTypes: []string{"image/*"}}), "report.png", "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the detected content type to be enforced, got %v", err)
+	}
+	if _, err := upload(mint(UploadClaims{UploadID: "another"}), "report.pdf", "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected token bound to another upload to be rejected, got %v", err)
+	}
+
+	token := mint(UploadClaims{UploadID: "tokened", Owner: "user-42", Prefix: "user-42/docs", MaxSize: 100, ContentTypes: []string{"application/pdf"}})
+	if _, err := upload(token, "report.pdf", "0"); err != nil {
+		t.Fatalf("Upload with valid token failed: %v", err)
+	}
+	if _, err := upload(mint(UploadClaims{Owner: "intruder"}), "report.pdf", "1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected another owner's chunk to be rejected, got %v", err)
+	}
+
+	result, err := upload(token, "report.pdf", "1")
+	if err != nil {
+		t.Fatalf("Upload with valid token failed: %v", err)
+	}
+	metadata := result["metadata"].(map[string]interface{})
+	if metadata["owner"] != "user-42" {
+		t.Errorf("Expected owner in metadata, got %v", metadata["owner"])
+	}
+	path, _ := metadata["path"].(string)
+	if filepath.Dir(path) != filepath.Join(dir, "uploads", "user-42", "docs") {
+		t.Errorf("Expected file under the token prefix, got %s", path)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "%PDF-1.7 data" {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestUploadToken_DetectedContentType(t *testing.T) {
+	images := UploadClaims{ContentTypes: []string{"image/*"}}
+	if err := checkDetectedType(images, "x.png", "application/octet-stream"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an executable named x.png to be rejected, got %v", err)
+	}
+	if err := checkDetectedType(images, "x.png", "image/png"); err != nil {
+		t.Errorf("Expected a PNG to be accepted, got %v", err)
+	}
+	if err := checkDetectedType(images, "logo.svg", "text/xml"); err != nil {
+		t.Errorf("Expected an SVG detected as XML to be accepted, got %v", err)
+	}
+	tables := UploadClaims{ContentTypes: []string{"text/csv"}}
+	if err := checkDetectedType(tables, "data.csv", "text/plain"); err != nil {
+		t.Errorf("Expected CSV detected as plain text to be accepted, got %v", err)
+	}
+	if err := checkDetectedType(tables, "data.csv", "application/octet-stream"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected binary content named data.csv to be rejected, got %v", err)
+	}
+	if err := checkDetectedType(images, "x.avif", "application/octet-stream"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected unrecognized content named x.avif to be rejected, got %v", err)
+	}
+	if err := checkDetectedType(images, "x.avif", "text/plain"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected text named x.avif to be rejected, got %v", err)
+	}
+	opaque := UploadClaims{ContentTypes: []string{"image/avif", "application/octet-stream"}}
+	if err := checkDetectedType(opaque, "x.avif", "application/octet-stream"); err != nil {
+		t.Errorf("Expected unrecognized content to be accepted when the token allows it, got %v", err)
+	}
+}
+
+func TestUploader_TokenBoundsRequestBody(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("token-secret")
+	u := NewUploader(Config{
+		TempDir:         filepath.Join(dir, "chunks"),
+		UploadDir:       filepath.Join(dir, "uploads"),
+		TokenSecret:     secret,
+		MaxRequestBytes: 1 << 20,
+	})
+	token, _ := NewUploadToken(secret, UploadClaims{MaxSize: 10, ExpiresAt: time.Now().Add(time.Minute)})
+
+	req, _ := createUploadForm(map[string]string{
+		"fileName":    "big.bin",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "10",
+	}, make([]byte, 5<<20))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, err := u.Handle(req); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected the body to be bounded by the token's size limit, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "chunks")); len(entries) != 0 {
+		t.Errorf("Expected nothing to be staged, found %d files", len(entries))
+	}
+
+	// Without a token outside the body, the body is bounded before parsing
+	unlimited, _ := NewUploadToken(secret, UploadClaims{ExpiresAt: time.Now().Add(time.Minute)})
+	req, _ = createUploadForm(map[string]string{
+		"uploadToken": unlimited,
+		"fileName":    "big.bin",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    fmt.Sprint(5 << 20),
+	}, make([]byte, 5<<20))
+	if _, err := u.Handle(req); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected a form token request to be bounded by MaxRequestBytes, got %v", err)
+	}
+	req, _ = createUploadForm(map[string]string{"fileName": "big.bin"}, make([]byte, 5<<20))
+	if _, err := u.Handle(req); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected a request without a token to be bounded by MaxRequestBytes, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package chunkeduploader
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies this package's spans.
+const tracerName = "github.com/anandhuremanan/chunked-uploader"
+
+// Span attribute keys.
+const (
+	attrUploadID    = attribute.Key("upload.id")
+	attrFileName    = attribute.Key("upload.file_name")
+	attrFileSize    = attribute.Key("upload.file_size")
+	attrChunkIndex  = attribute.Key("upload.chunk_index")
+	attrTotalChunks = attribute.Key("upload.total_chunks")
+	attrChunkBytes  = attribute.Key("upload.chunk_bytes")
+	attrStitchBytes = attribute.Key("upload.stitched_bytes")
+	attrHook        = attribute.Key("upload.hook")
+	attrStep        = attribute.Key("upload.processing_step")
+	attrAttempts    = attribute.Key("upload.processing_attempts")
+)
+
+// startRequestSpan continues the trace carried by the request headers, if
+// any, and starts the span covering one chunk request.
+func (u *Uploader) startRequestSpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := u.config.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return u.tracer.Start(ctx, "chunkeduploader.Handle", trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// startHookSpan starts the span for one hook call.
+func (u *Uploader) startHookSpan(ctx context.Context, hook string) (context.Context, trace.Span) {
+	return u.tracer.Start(ctx, "chunkeduploader.Hook "+hook, trace.WithAttributes(attrHook.String(hook)))
+}
+
+// sessionAttributes describes the upload a span belongs to.
+func sessionAttributes(info SessionInfo) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrUploadID.String(info.UploadID),
+		attrFileName.String(info.FileName),
+		attrFileSize.Int64(info.FileSize),
+		attrTotalChunks.Int(info.TotalChunks),
+	}
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
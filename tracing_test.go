@@ -0,0 +1,85 @@
+package chunkeduploader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestUploader_TracingSpans(t *testing.T) {
+	dir := t.TempDir()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	u := NewUploader(Config{
+		TempDir:        filepath.Join(dir, "chunks"),
+		UploadDir:      filepath.Join(dir, "uploads"),
+		TracerProvider: provider,
+		Hooks: Hooks{
+			OnChunkReceived: func(context.Context, SessionInfo, ChunkInfo) error { return nil },
+		},
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "traced",
+		"fileName":    "traced.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Errorf("Span %s should continue the incoming trace, got trace %s", span.Name, got)
+		}
+	}
+
+	root, ok := spans["chunkeduploader.Handle"]
+	if !ok {
+		t.Fatal("Expected a span for the request")
+	}
+	if !root.Parent.IsRemote() || root.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Request span should be a child of the incoming span, got parent %v", root.Parent)
+	}
+	if !hasAttribute(root.Attributes, attrUploadID.String("traced")) || !hasAttribute(root.Attributes, attrChunkIndex.Int(0)) {
+		t.Errorf("Expected upload attributes on request span, got %v", root.Attributes)
+	}
+
+	for _, name := range []string{"chunkeduploader.ParseForm", "chunkeduploader.PersistChunk", "chunkeduploader.Hook OnChunkReceived", "chunkeduploader.StitchFile"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected span %s", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("Span %s should be a child of the request span", name)
+		}
+	}
+	if !hasAttribute(spans["chunkeduploader.PersistChunk"].Attributes, attrChunkBytes.Int64(5)) {
+		t.Errorf("Expected chunk byte count, got %v", spans["chunkeduploader.PersistChunk"].Attributes)
+	}
+	if !hasAttribute(spans["chunkeduploader.StitchFile"].Attributes, attrStitchBytes.Int64(5)) {
+		t.Errorf("Expected stitched byte count, got %v", spans["chunkeduploader.StitchFile"].Attributes)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
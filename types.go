@@ -1,15 +1,174 @@
 package chunkeduploader
 
-import "sync"
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type ChunkInfo struct {
 	FileName    string `json:"fileName"`
 	ChunkIndex  int    `json:"chunkIndex"`
 	TotalChunks int    `json:"totalChunks"`
 	FileSize    int64  `json:"fileSize"`
+	Size        int64  `json:"size"`   // size of this chunk in bytes
+	Digest      string `json:"digest"` // hex-encoded SHA-256 of this chunk
+
+	// ContentType is the media type detected from the content of the first
+	// chunk; it is empty for the other chunks.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// SessionState describes where an upload session is in its lifecycle.
+// Sessions move from receiving to assembling exactly once, and from
+// assembling to either complete or failed. A receiving session can
+// instead be aborted, or expire when it stops receiving chunks.
+type SessionState string
+
+const (
+	StateReceiving  SessionState = "receiving"
+	StateAssembling SessionState = "assembling"
+	StateComplete   SessionState = "complete"
+	StateFailed     SessionState = "failed"
+	StateAborted    SessionState = "aborted"
+	StateExpired    SessionState = "expired"
+)
+
+// ConflictPolicy decides what happens when a chunk index is uploaded again
+// with content that differs from the chunk already stored for it.
+type ConflictPolicy int
+
+const (
+	// RejectConflictingChunks keeps the stored chunk and fails the upload
+	// request with ErrChunkConflict. This is the default.
+	RejectConflictingChunks ConflictPolicy = iota
+	// OverwriteConflictingChunks replaces the stored chunk with the new one.
+	OverwriteConflictingChunks
+)
+
+// Config holds the settings of an Uploader. Zero values select the defaults.
+type Config struct {
+	TempDir        string         // directory for staged chunks, default "./temp_chunks"
+	UploadDir      string         // directory for assembled files, default "./uploads"
+	MaxMemory      int64          // multipart form memory limit, default 32MB
+	ConflictPolicy ConflictPolicy // handling of re-uploaded chunks with different content
+	OnEvent        func(Event)    // called when an upload completes, fails, is aborted or expires
+	EventSink      EventSink      // receives the same events as OnEvent, see NewFanOut
+	Hooks          Hooks          // application callbacks for each upload stage
+	AllowedOrigins []string       // origins besides the server's own that may open progress WebSockets
+	SessionTTL     time.Duration  // idle time after which ExpireSessions drops an upload
+
+	// OnAssemblyProgress is called as chunks are stitched into the final file.
+	OnAssemblyProgress func(Progress)
+
+	Timeouts Timeouts // per-operation time limits, none by default
+
+	// StateFile, if set, is where Shutdown saves unfinished sessions and
+	// where NewUploader restores them from.
+	StateFile string
+
+	Metrics *Metrics // collects upload statistics, none if nil
+
+	Logger *slog.Logger // structured log output, discarded by default
+
+	// TracerProvider creates the spans for chunk requests and assembly. It
+	// defaults to the global OpenTelemetry provider, which does nothing
+	// until the application installs one.
+	TracerProvider trace.TracerProvider
+	// Propagator reads the incoming trace context from request headers.
+	// It defaults to W3C Trace Context.
+	Propagator propagation.TextMapPropagator
+
+	// TokenSecret, if set, requires every request to carry an upload token
+	// or come through a pre-signed URL signed with it; see NewUploadToken
+	// and PresignUploadURL.
+	TokenSecret []byte
+	// MaxRequestBytes bounds chunk requests whose token is sent in the form
+	// and so can only be checked once the body is parsed. The default is
+	// MaxMemory plus 1MB, so unauthenticated requests cannot fill the disk.
+	MaxRequestBytes int64
+
+	RateLimit RateLimit // per-client limits on chunk requests, none by default
+
+	Quota Quota // storage limits per owner and overall, none by default
+
+	TypePolicy TypePolicy // which detected content types are accepted, all by default
+
+	// Scanner, if set, checks every assembled file before it is published.
+	// Files that do not scan clean are moved to QuarantineDir.
+	Scanner       Scanner
+	QuarantineDir string // default "./quarantine"
+
+	// Processors post-process every assembled file that scanned clean.
+	Processors []ProcessingStep
+
+	// ContentStore, if set, stores assembled files by content digest after
+	// the synchronous processing steps, deduplicating identical uploads.
+	ContentStore *ContentStore
+}
+
+// Timeouts bound individual operations of an Uploader. Zero disables a limit.
+type Timeouts struct {
+	ChunkWrite time.Duration // receiving and persisting a single chunk
+	Hook       time.Duration // each hook call
+	Assembly   time.Duration // stitching a file together, including OnComplete
+}
+
+// Uploader receives chunked uploads and assembles them into files.
+type Uploader struct {
+	config   Config
+	files    *FileManager
+	progress *ProgressBroker
+	tracer   trace.Tracer
+	limiter  *rateLimiter  // nil without a rate limit
+	quota    *quotaTracker // nil without a quota
+
+	lifecycle sync.Mutex     // guards closing against new operations
+	closing   bool           // Shutdown was called
+	inflight  sync.WaitGroup // chunk requests, including any assembly they run
+	stopCtx   context.Context
+	stop      context.CancelFunc // cancels in-flight operations at the shutdown deadline
+}
+
+// chunkRecord describes a chunk that has been persisted to the temp directory.
+type chunkRecord struct {
+	path   string
+	size   int64
+	digest string // hex-encoded SHA-256 of the chunk content
+
+	contentType string // detected media type, only for the first chunk
+}
+
+// incomingChunk describes a staged chunk waiting to be committed to its session.
+type incomingChunk struct {
+	session    SessionInfo
+	chunkIndex int
+	chunkPath  string      // final location of the chunk in the temp directory
+	staged     chunkRecord // the chunk as written to its private staging file
+}
+
+// uploadSession tracks the chunks and assembly outcome of a single upload.
+type uploadSession struct {
+	info   SessionInfo
+	chunks []chunkRecord
+	state  SessionState
+	done   chan struct{} // closed once the session is complete, failed, aborted or expired
+	result map[string]interface{}
+	err    error
+
+	updatedAt time.Time // when the session last accepted a chunk
+
+	assembledChunks int                // chunks copied into the final file so far
+	assembledBytes  int64              // bytes written to the final file so far
+	cancelAssembly  context.CancelFunc // stops an assembly in progress
+	cancelRequested bool               // CancelAssembly was called, possibly before cancelAssembly was set
 }
 
 type FileManager struct {
-	chunks map[string][]string // fileName -> []chunkPaths
-	mutex  sync.RWMutex
+	sessions map[string]*uploadSession // upload key -> session
+	mutex    sync.RWMutex
 }
@@ -2,14 +2,20 @@ package chunkeduploader
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Helper function to create multipart form data for testing
@@ -38,6 +44,28 @@ func createMultipartForm(fileName string, chunkIndex, totalChunks int, fileSize
 	return req, nil
 }
 
+// Helper function to create multipart form data with arbitrary form fields
+func createUploadForm(fields map[string]string, chunkData []byte) (*http.Request, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+
+	part, err := writer.CreateFormFile("chunk", "chunk")
+	if err != nil {
+		return nil, err
+	}
+	part.Write(chunkData)
+
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
 func TestFileManager_AddChunk(t *testing.T) {
 	fm := NewFileManager()
 	fileName := "test.txt"
@@ -367,13 +395,15 @@ func TestUploaderHelper_InvalidAdditionalParams(t *testing.T) {
 }
 
 func TestUploaderHelper_ChunkReceivedWithAdditionalParams(t *testing.T) {
+	fileName := "test.txt"
+
 	// Setup cleanup
 	defer func() {
 		os.RemoveAll("./temp_chunks")
 		os.RemoveAll("./uploads")
+		fileManager.RemoveFile(fileName)
 	}()
 
-	fileName := "test.txt"
 	chunk1Data := []byte("Hello, ")
 	totalSize := int64(13) // Total size for both chunks
 	additionalParams := `{"sessionId":"abc123"}`
@@ -428,9 +458,10 @@ func TestStitchFile_SizeMismatch(t *testing.T) {
 
 	// Add chunk to file manager
 	fileManager.AddChunk(fileName, chunkPath, 0, 1)
+	defer fileManager.RemoveFile(fileName)
 
 	// Try to stitch with wrong size
-	_, err = stitchFile(fileName, wrongSize)
+	_, err = defaultUploader.stitchFile(context.Background(), "", fileName, fileManager.GetChunks(fileName), wrongSize, nil)
 	if err == nil {
 		t.Error("Expected error for size mismatch")
 	}
@@ -466,7 +497,7 @@ func TestCleanupChunks(t *testing.T) {
 	}
 
 	// Cleanup
-	cleanupChunks(fileName)
+	defaultUploader.cleanupChunks(fileName)
 
 	// Verify files are deleted
 	if _, err := os.Stat(chunkPath1); !os.IsNotExist(err) {
@@ -486,6 +517,745 @@ func TestCleanupChunks(t *testing.T) {
 	os.RemoveAll(tempDir)
 }
 
+func TestUploaderHelper_FinalChunkRetriesStitchOnce(t *testing.T) {
+	// Setup cleanup
+	defer func() {
+		os.RemoveAll("./temp_chunks")
+		os.RemoveAll("./uploads")
+	}()
+
+	fileName := "retry.txt"
+	uploadID := "retry-upload-1"
+	chunk1Data := []byte("Hello, ")
+	chunk2Data := []byte("World!")
+	totalSize := int64(len(chunk1Data) + len(chunk2Data))
+
+	send := func(chunkIndex int, data []byte) (map[string]interface{}, error) {
+		req, err := createUploadForm(map[string]string{
+			"uploadId":    uploadID,
+			"fileName":    fileName,
+			"chunkIndex":  fmt.Sprintf("%d", chunkIndex),
+			"totalChunks": "2",
+			"fileSize":    fmt.Sprintf("%d", totalSize),
+		}, data)
+		if err != nil {
+			return nil, err
+		}
+		return UploaderHelper(req)
+	}
+
+	if _, err := send(0, chunk1Data); err != nil {
+		t.Fatalf("UploaderHelper failed for chunk 1: %v", err)
+	}
+
+	// Fire the final chunk several times concurrently
+	const retries = 8
+	results := make([]map[string]interface{}, retries)
+	errs := make([]error, retries)
+	var wg sync.WaitGroup
+	for i := 0; i < retries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = send(1, chunk2Data)
+		}(i)
+	}
+	wg.Wait()
+
+	// A late sequential retry must also get the original outcome
+	late, err := send(1, chunk2Data)
+	if err != nil {
+		t.Fatalf("Late retry failed: %v", err)
+	}
+	results = append(results, late)
+
+	var storedName string
+	for i, result := range results {
+		if i < retries && errs[i] != nil {
+			t.Fatalf("Retry %d failed: %v", i, errs[i])
+		}
+		if result["status"] != "complete" {
+			t.Fatalf("Retry %d: expected status 'complete', got %v", i, result["status"])
+		}
+		name := result["metadata"].(map[string]interface{})["storedName"].(string)
+		if storedName == "" {
+			storedName = name
+		} else if name != storedName {
+			t.Errorf("Retry %d: expected storedName %s, got %s", i, storedName, name)
+		}
+	}
+
+	entries, err := os.ReadDir("./uploads")
+	if err != nil {
+		t.Fatalf("Failed to read uploads directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected file to be stitched once, found %d files", len(entries))
+	}
+
+	content, err := os.ReadFile(filepath.Join("./uploads", storedName))
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if string(content) != "Hello, World!" {
+		t.Errorf("File content mismatch, got %s", content)
+	}
+
+	if state, ok := fileManager.State(uploadID); !ok || state != StateComplete {
+		t.Errorf("Expected session state %s, got %s", StateComplete, state)
+	}
+	fileManager.RemoveFile(uploadID)
+}
+
+func TestUploader_DuplicateChunkRetry(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{TempDir: filepath.Join(dir, "chunks"), UploadDir: filepath.Join(dir, "uploads")})
+
+	fields := map[string]string{
+		"uploadId":    "dup-upload",
+		"fileName":    "dup.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "13",
+	}
+
+	req, _ := createUploadForm(fields, []byte("Hello, "))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Handle failed for first chunk: %v", err)
+	}
+
+	chunkPath := u.files.GetChunks("dup-upload")[0]
+	before, err := os.Stat(chunkPath)
+	if err != nil {
+		t.Fatalf("Failed to stat chunk: %v", err)
+	}
+
+	// An identical retry is acknowledged without rewriting the chunk
+	req, _ = createUploadForm(fields, []byte("Hello, "))
+	result, err := u.Handle(req)
+	if err != nil {
+		t.Fatalf("Handle failed for identical retry: %v", err)
+	}
+	if result["status"] != "chunk_received" || result["duplicate"] != true {
+		t.Errorf("Expected duplicate chunk_received, got %v", result)
+	}
+
+	after, err := os.Stat(chunkPath)
+	if err != nil {
+		t.Fatalf("Failed to stat chunk: %v", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Identical retry should not rewrite the chunk file")
+	}
+
+	// A retry with different content is a conflict
+	req, _ = createUploadForm(fields, []byte("Howdy, "))
+	_, err = u.Handle(req)
+	if !errors.Is(err, ErrChunkConflict) {
+		t.Fatalf("Expected ErrChunkConflict, got %v", err)
+	}
+
+	content, _ := os.ReadFile(chunkPath)
+	if string(content) != "Hello, " {
+		t.Errorf("Conflicting retry should keep the stored chunk, got %s", content)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "chunks")); len(entries) != 1 {
+		t.Errorf("Expected staged files to be discarded, found %d entries", len(entries))
+	}
+}
+
+func TestUploader_ConflictingChunkOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:        filepath.Join(dir, "chunks"),
+		UploadDir:      filepath.Join(dir, "uploads"),
+		ConflictPolicy: OverwriteConflictingChunks,
+	})
+
+	fields := map[string]string{
+		"uploadId":    "overwrite-upload",
+		"fileName":    "overwrite.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "13",
+	}
+
+	req, _ := createUploadForm(fields, []byte("Howdy, "))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Handle failed for first chunk: %v", err)
+	}
+
+	req, _ = createUploadForm(fields, []byte("Hello, "))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Overwrite should be allowed, got %v", err)
+	}
+
+	fields["chunkIndex"] = "1"
+	req, _ = createUploadForm(fields, []byte("World!"))
+	result, err := u.Handle(req)
+	if err != nil {
+		t.Fatalf("Handle failed for final chunk: %v", err)
+	}
+
+	metadata := result["metadata"].(map[string]interface{})
+	content, err := os.ReadFile(metadata["path"].(string))
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if string(content) != "Hello, World!" {
+		t.Errorf("Expected overwritten content, got %s", content)
+	}
+}
+
+func TestUploader_Abort(t *testing.T) {
+	dir := t.TempDir()
+	var events []Event
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		OnEvent:   func(e Event) { events = append(events, e) },
+	})
+
+	fields := map[string]string{
+		"uploadId":    "abort-upload",
+		"fileName":    "abort.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "13",
+	}
+
+	req, _ := createUploadForm(fields, []byte("Hello, "))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Handle failed for first chunk: %v", err)
+	}
+	chunkPath := u.files.GetChunks("abort-upload")[0]
+
+	result, err := u.Handle(httptest.NewRequest("DELETE", "/upload?uploadId=abort-upload", nil))
+	if err != nil {
+		t.Fatalf("Abort request failed: %v", err)
+	}
+	if result["status"] != "aborted" {
+		t.Errorf("Expected status 'aborted', got %v", result["status"])
+	}
+
+	if _, err := os.Stat(chunkPath); !os.IsNotExist(err) {
+		t.Error("Staged chunk should be deleted after abort")
+	}
+	if state, _ := u.files.State("abort-upload"); state != StateAborted {
+		t.Errorf("Expected session state %s, got %s", StateAborted, state)
+	}
+
+	if len(events) != 1 || events[0].Type != EventAborted || events[0].UploadID != "abort-upload" || events[0].FileName != "abort.txt" {
+		t.Errorf("Expected a single abort event, got %+v", events)
+	}
+
+	// Late chunks are rejected
+	fields["chunkIndex"] = "1"
+	req, _ = createUploadForm(fields, []byte("World!"))
+	if _, err := u.Handle(req); !errors.Is(err, ErrUploadAborted) {
+		t.Errorf("Expected ErrUploadAborted for late chunk, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 0 {
+		t.Error("Aborted upload should not be stitched")
+	}
+
+	if err := u.Abort(context.Background(), "abort-upload"); !errors.Is(err, ErrUploadAborted) {
+		t.Errorf("Expected ErrUploadAborted for repeated abort, got %v", err)
+	}
+	if err := u.Abort(context.Background(), "unknown-upload"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestUploader_AbortByFileName(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+	})
+
+	req, _ := createMultipartForm("photo.txt", 0, 2, 13, []byte("Hello, "), "")
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Handle failed for first chunk: %v", err)
+	}
+	if err := u.Abort(context.Background(), "photo.txt"); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+
+	// Without an uploadId the aborted session is forgotten, so the same file
+	// name can be uploaded again
+	var result map[string]interface{}
+	for i, data := range []string{"Hello, ", "World!"} {
+		req, _ := createMultipartForm("photo.txt", i, 2, 13, []byte(data), "")
+		var err error
+		if result, err = u.Handle(req); err != nil {
+			t.Fatalf("Upload after abort failed for chunk %d: %v", i, err)
+		}
+	}
+	path, _ := result["metadata"].(map[string]interface{})["path"].(string)
+	if content, _ := os.ReadFile(path); string(content) != "Hello, World!" {
+		t.Errorf("Expected the new upload to complete, got %q", content)
+	}
+}
+
+func TestUploader_Hooks(t *testing.T) {
+	dir := t.TempDir()
+	var received []ChunkInfo
+	var completed, failed, aborted []SessionInfo
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Hooks: Hooks{
+			OnChunkReceived: func(ctx context.Context, session SessionInfo, chunk ChunkInfo) error {
+				if session.AdditionalParams["token"] != "secret" {
+					return errors.New("unauthorized")
+				}
+				received = append(received, chunk)
+				return nil
+			},
+			OnComplete: func(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error {
+				if session.FileName == "veto.txt" {
+					return errors.New("not allowed")
+				}
+				metadata["recordId"] = 42
+				completed = append(completed, session)
+				return nil
+			},
+			OnError: func(ctx context.Context, session SessionInfo, err error) {
+				failed = append(failed, session)
+			},
+			OnAbort: func(ctx context.Context, session SessionInfo) {
+				aborted = append(aborted, session)
+			},
+		},
+	})
+
+	upload := func(uploadID, fileName, params string) (map[string]interface{}, error) {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":         uploadID,
+			"fileName":         fileName,
+			"chunkIndex":       "0",
+			"totalChunks":      "1",
+			"fileSize":         "5",
+			"additionalParams": params,
+		}, []byte("Hello"))
+		return u.Handle(req)
+	}
+
+	// A hook error rejects the chunk
+	if _, err := upload("hook-unauthorized", "a.txt", `{"token":"wrong"}`); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("Expected chunk to be rejected, got %v", err)
+	}
+	if _, ok := u.files.State("hook-unauthorized"); ok {
+		t.Error("Rejected chunk should not create a session")
+	}
+
+	// Accepted uploads see the hook's metadata
+	result, err := upload("hook-ok", "ok.txt", `{"token":"secret"}`)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if result["metadata"].(map[string]interface{})["recordId"] != 42 {
+		t.Errorf("Expected OnComplete to add metadata, got %v", result["metadata"])
+	}
+	if len(received) != 1 || received[0].Size != 5 || received[0].Digest == "" {
+		t.Errorf("Expected chunk info with size and digest, got %+v", received)
+	}
+	if len(completed) != 1 || completed[0].UploadID != "hook-ok" {
+		t.Errorf("Expected OnComplete for hook-ok, got %+v", completed)
+	}
+
+	// OnComplete can veto finalization
+	if _, err := upload("hook-veto", "veto.txt", `{"token":"secret"}`); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected finalization to be vetoed, got %v", err)
+	}
+	if state, _ := u.files.State("hook-veto"); state != StateFailed {
+		t.Errorf("Expected vetoed session to be %s, got %s", StateFailed, state)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 1 {
+		t.Errorf("Vetoed file should be removed, found %d files", len(entries))
+	}
+	if len(failed) != 2 {
+		t.Errorf("Expected OnError for rejection and veto, got %d calls", len(failed))
+	}
+
+	// OnAbort receives the session
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":         "hook-abort",
+		"fileName":         "abort.txt",
+		"chunkIndex":       "0",
+		"totalChunks":      "2",
+		"fileSize":         "10",
+		"additionalParams": `{"token":"secret"}`,
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if err := u.Abort(context.Background(), "hook-abort"); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if len(aborted) != 1 || aborted[0].FileName != "abort.txt" || aborted[0].FileSize != 10 {
+		t.Errorf("Expected OnAbort with session info, got %+v", aborted)
+	}
+}
+
+func TestUploader_AssemblyProgress(t *testing.T) {
+	dir := t.TempDir()
+	var reports []Progress
+	u := NewUploader(Config{
+		TempDir:            filepath.Join(dir, "chunks"),
+		UploadDir:          filepath.Join(dir, "uploads"),
+		OnAssemblyProgress: func(p Progress) { reports = append(reports, p) },
+	})
+
+	chunks := []string{"one,", "two,", "three"}
+	for i, data := range chunks {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "assembly-progress",
+			"fileName":    "progress.txt",
+			"chunkIndex":  fmt.Sprintf("%d", i),
+			"totalChunks": "3",
+			"fileSize":    "13",
+		}, []byte(data))
+		if _, err := u.Handle(req); err != nil {
+			t.Fatalf("Upload of chunk %d failed: %v", i, err)
+		}
+	}
+
+	if len(reports) != 3 {
+		t.Fatalf("Expected a report per chunk, got %+v", reports)
+	}
+	for i, p := range reports {
+		if p.State != StateAssembling || p.AssembledChunks != i+1 {
+			t.Errorf("Report %d: unexpected progress %+v", i, p)
+		}
+	}
+	if last := reports[2]; last.AssembledBytes != 13 {
+		t.Errorf("Expected 13 bytes assembled, got %d", last.AssembledBytes)
+	}
+
+	if p, _ := u.Progress("assembly-progress"); p.AssembledBytes != 13 || p.State != StateComplete {
+		t.Errorf("Expected status to report assembled bytes, got %+v", p)
+	}
+}
+
+func TestUploader_CancelAssembly(t *testing.T) {
+	dir := t.TempDir()
+	var u *Uploader
+	u = NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		OnAssemblyProgress: func(p Progress) {
+			if err := u.CancelAssembly(p.UploadID); err != nil {
+				t.Errorf("CancelAssembly failed: %v", err)
+			}
+		},
+	})
+
+	for i, data := range []string{"Hello, ", "World!"} {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "assembly-cancel",
+			"fileName":    "cancel.txt",
+			"chunkIndex":  fmt.Sprintf("%d", i),
+			"totalChunks": "2",
+			"fileSize":    "13",
+		}, []byte(data))
+		_, err := u.Handle(req)
+		if i == 1 && (err == nil || !errors.Is(err, context.Canceled)) {
+			t.Fatalf("Expected cancelled assembly, got %v", err)
+		}
+	}
+
+	if state, _ := u.files.State("assembly-cancel"); state != StateFailed {
+		t.Errorf("Expected cancelled session to be %s, got %s", StateFailed, state)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 0 {
+		t.Errorf("Partial output should be removed, found %d files", len(entries))
+	}
+	if err := u.CancelAssembly("assembly-cancel"); err == nil {
+		t.Error("Expected error cancelling a finished upload")
+	}
+}
+
+func TestStitchFile_CancelledContext(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{TempDir: dir, UploadDir: filepath.Join(dir, "uploads")})
+
+	chunkPath := filepath.Join(dir, "chunk_0")
+	os.WriteFile(chunkPath, []byte("Hello"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := u.stitchFile(ctx, "", "cancel.txt", []string{chunkPath}, 5, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "uploads")); len(entries) != 0 {
+		t.Errorf("Partial output should be removed, found %d files", len(entries))
+	}
+}
+
+func TestUploader_CancelledRequestRemovesChunk(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Hooks: Hooks{
+			// Simulate the client disconnecting while the chunk is being checked
+			OnChunkReceived: func(context.Context, SessionInfo, ChunkInfo) error {
+				cancel()
+				return nil
+			},
+		},
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "cancelled-request",
+		"fileName":    "cancel.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "10",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req.WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "chunks")); len(entries) != 0 {
+		t.Errorf("Cancelled chunk should be removed, found %d files", len(entries))
+	}
+	if _, ok := u.files.State("cancelled-request"); ok {
+		t.Error("Cancelled chunk should not create a session")
+	}
+
+	// A request that is already cancelled is not parsed at all
+	req, _ = createUploadForm(map[string]string{
+		"uploadId":    "cancelled-request",
+		"fileName":    "cancel.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "10",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req.WithContext(ctx)); err == nil {
+		t.Error("Expected error for cancelled request")
+	}
+}
+
+func TestUploader_Timeouts(t *testing.T) {
+	dir := t.TempDir()
+	waitForDeadline := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Timeouts:  Timeouts{Hook: 20 * time.Millisecond, Assembly: 20 * time.Millisecond},
+		Hooks: Hooks{
+			OnChunkReceived: func(ctx context.Context, session SessionInfo, chunk ChunkInfo) error {
+				if session.FileName == "slow-chunk.txt" {
+					return waitForDeadline(ctx)
+				}
+				return nil
+			},
+			OnComplete: func(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error {
+				return waitForDeadline(ctx)
+			},
+		},
+	})
+
+	upload := func(fileName string) error {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    strings.TrimSuffix(fileName, ".txt"),
+			"fileName":    fileName,
+			"chunkIndex":  "0",
+			"totalChunks": "1",
+			"fileSize":    "5",
+		}, []byte("Hello"))
+		_, err := u.Handle(req)
+		return err
+	}
+
+	if err := upload("slow-chunk.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected hook timeout, got %v", err)
+	}
+	if err := upload("slow-complete.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected finalization timeout, got %v", err)
+	}
+}
+
+func TestUploader_ShutdownDrainsInFlightChunks(t *testing.T) {
+	dir := t.TempDir()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Hooks: Hooks{
+			OnChunkReceived: func(context.Context, SessionInfo, ChunkInfo) error {
+				close(entered)
+				<-release
+				return nil
+			},
+		},
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "drain",
+		"fileName":    "drain.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	handled := make(chan error, 1)
+	go func() {
+		_, err := u.Handle(req)
+		handled <- err
+	}()
+	<-entered
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- u.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned before the in-flight chunk finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-handled; err != nil {
+		t.Errorf("In-flight upload should complete, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+
+	req, _ = createUploadForm(map[string]string{
+		"uploadId":    "late",
+		"fileName":    "late.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown after shutdown, got %v", err)
+	}
+}
+
+func TestUploader_ShutdownDeadlineRollsBackAndResumes(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		StateFile: filepath.Join(dir, "sessions.json"),
+	}
+
+	// Hold the assembly until the shutdown deadline cancels it
+	entered := make(chan struct{})
+	interrupted := config
+	interrupted.Hooks.OnComplete = func(ctx context.Context, session SessionInfo, metadata map[string]interface{}) error {
+		close(entered)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	u := NewUploader(interrupted)
+
+	upload := func(u *Uploader, index int, data string) error {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "resume",
+			"fileName":    "resume.txt",
+			"chunkIndex":  fmt.Sprintf("%d", index),
+			"totalChunks": "2",
+			"fileSize":    "13",
+		}, []byte(data))
+		_, err := u.Handle(req)
+		return err
+	}
+
+	if err := upload(u, 0, "Hello, "); err != nil {
+		t.Fatalf("Upload of chunk 0 failed: %v", err)
+	}
+	handled := make(chan error, 1)
+	go func() { handled <- upload(u, 1, "World!") }()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := u.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected shutdown deadline error, got %v", err)
+	}
+	if err := <-handled; !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected interrupted assembly to report ErrShuttingDown, got %v", err)
+	}
+	if entries, _ := os.ReadDir(config.UploadDir); len(entries) != 0 {
+		t.Errorf("Partial output should be removed, found %d files", len(entries))
+	}
+
+	// A new uploader picks the session up and completes it on a retry
+	restarted := NewUploader(config)
+	if p, ok := restarted.Progress("resume"); !ok || p.State != StateReceiving || p.ReceivedChunks != 2 {
+		t.Fatalf("Expected restored session with both chunks, got %+v (found %v)", p, ok)
+	}
+	if err := upload(restarted, 1, "World!"); err != nil {
+		t.Fatalf("Retried chunk should complete the upload, got %v", err)
+	}
+	if state, _ := restarted.files.State("resume"); state != StateComplete {
+		t.Errorf("Expected %s, got %s", StateComplete, state)
+	}
+	if _, err := os.Stat(config.StateFile); !os.IsNotExist(err) {
+		t.Error("State file should be removed once restored")
+	}
+}
+
+func TestUploader_StructuredLogging(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		Logger:    slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "logged",
+		"fileName":    "logged.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	records := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		records[record["msg"].(string)] = record
+	}
+
+	chunk := records["chunk received"]
+	if chunk == nil || chunk["level"] != "DEBUG" || chunk["uploadId"] != "logged" || chunk["chunkIndex"] != float64(0) || chunk["bytes"] != float64(5) {
+		t.Errorf("Expected structured chunk record, got %v", chunk)
+	}
+	stitched := records["stitched file"]
+	if stitched == nil || stitched["level"] != "INFO" || stitched["fileName"] != "logged.txt" || stitched["duration"] == nil {
+		t.Errorf("Expected structured stitch record, got %v", stitched)
+	}
+	if records["deleted chunk"] == nil {
+		t.Error("Expected chunk deletion to be logged at debug level")
+	}
+}
+
 // Benchmark tests
 func BenchmarkFileManager_AddChunk(b *testing.B) {
 	fm := NewFileManager()
@@ -513,6 +1283,41 @@ func BenchmarkFileManager_IsComplete(b *testing.B) {
 }
 
 // Test concurrent access to FileManager
+func TestUploader_ChunksExceedingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+	})
+	upload := func(index string, data string) error {
+		req, _ := createUploadForm(map[string]string{
+			"uploadId":    "oversized",
+			"fileName":    "test.txt",
+			"chunkIndex":  index,
+			"totalChunks": "3",
+			"fileSize":    "10",
+		}, []byte(data))
+		_, err := u.Handle(req)
+		return err
+	}
+
+	if err := upload("0", strings.Repeat("x", 11)); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected a chunk larger than the file to be rejected, got %v", err)
+	}
+	if err := upload("0", "12345"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if err := upload("1", "123456"); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected chunks adding up to more than the file to be rejected, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "chunks")); len(entries) != 1 {
+		t.Errorf("Expected only the first chunk to be staged, found %d files", len(entries))
+	}
+	if got := statusCode(fmt.Errorf("wrapped: %w", ErrUploadTooLarge)); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized uploads, got %d", got)
+	}
+}
+
 func TestFileManager_ConcurrentAccess(t *testing.T) {
 	fm := NewFileManager()
 	fileName := "concurrent_test.txt"
@@ -552,3 +1357,52 @@ func TestFileManager_ConcurrentAccess(t *testing.T) {
 		}
 	}
 }
+
+func TestUploader_ExpireSessions(t *testing.T) {
+	dir := t.TempDir()
+	var events []Event
+	u := NewUploader(Config{
+		TempDir:    filepath.Join(dir, "chunks"),
+		UploadDir:  filepath.Join(dir, "uploads"),
+		SessionTTL: time.Millisecond,
+		OnEvent:    func(e Event) { events = append(events, e) },
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "expiring-upload",
+		"fileName":    "slow.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "2",
+		"fileSize":    "10",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	chunkPath := u.files.GetChunks("expiring-upload")[0]
+
+	time.Sleep(5 * time.Millisecond)
+	if n := u.ExpireSessions(context.Background()); n != 1 {
+		t.Fatalf("Expected 1 expired session, got %d", n)
+	}
+	if _, err := os.Stat(chunkPath); !os.IsNotExist(err) {
+		t.Error("Expired chunks should be deleted")
+	}
+	if len(events) != 1 || events[0].Type != EventExpired {
+		t.Errorf("Expected expired event, got %+v", events)
+	}
+
+	// Without an uploadId the expired session is forgotten, so the same file
+	// name can be uploaded again
+	req, _ = createMultipartForm("slow.txt", 0, 2, 10, []byte("Hello"), "")
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n := u.ExpireSessions(context.Background()); n != 1 {
+		t.Fatalf("Expected 1 expired session, got %d", n)
+	}
+	req, _ = createMultipartForm("slow.txt", 0, 1, 5, []byte("Hello"), "")
+	if _, err := u.Handle(req); err != nil {
+		t.Errorf("Expected the file name to be usable after expiry, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package chunkeduploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256
+	// of the request body, keyed with the webhook secret.
+	WebhookSignatureHeader = "X-Upload-Signature"
+	// WebhookDeliveryHeader carries the event ID, which stays the same across
+	// retries of one delivery, so receivers can drop duplicates.
+	WebhookDeliveryHeader = "X-Upload-Delivery"
+)
+
+// WebhookConfig configures a WebhookDispatcher. Zero values select the defaults.
+type WebhookConfig struct {
+	URLs           []string      // endpoints every event is POSTed to
+	Secret         []byte        // HMAC key for the signature header
+	Events         []EventType   // event types to deliver, all if empty
+	MaxAttempts    int           // attempts per URL before dead-lettering, default 5
+	InitialBackoff time.Duration // delay before the first retry, default 500ms
+	MaxBackoff     time.Duration // upper bound for the retry delay, default 30s
+	DeadLetterPath string        // JSON-lines file for undeliverable events, none if empty
+	Client         *http.Client  // HTTP client, default with a 10s timeout
+}
+
+// WebhookDispatcher POSTs upload events as signed JSON to configured URLs.
+// It is an EventSink, and its Notify method can be used as Config.OnEvent.
+type WebhookDispatcher struct {
+	config WebhookConfig
+
+	wg         sync.WaitGroup
+	deadLetter sync.Mutex // serializes writes to the dead-letter file
+}
+
+// deadLetterEntry is one line of the dead-letter file.
+type deadLetterEntry struct {
+	URL        string    `json:"url"`
+	DeliveryID string    `json:"deliveryId"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	Time       time.Time `json:"time"`
+	Event      Event     `json:"event"`
+}
+
+// NewWebhookDispatcher creates a dispatcher, filling unset configuration with defaults.
+func NewWebhookDispatcher(config WebhookConfig) *WebhookDispatcher {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookDispatcher{config: config}
+}
+
+// Notify delivers an event to every configured URL in the background.
+func (d *WebhookDispatcher) Notify(event Event) {
+	if !d.wants(event.Type) {
+		return
+	}
+
+	deliveryID := event.ID
+	if deliveryID == "" {
+		deliveryID = uuid.New().String()
+	}
+	for _, url := range d.config.URLs {
+		d.wg.Add(1)
+		go func(url string) {
+			defer d.wg.Done()
+			d.Deliver(context.Background(), url, deliveryID, event)
+		}(url)
+	}
+}
+
+// Publish delivers an event in the background, like Notify. Delivery
+// failures end up in the dead-letter file rather than being returned.
+func (d *WebhookDispatcher) Publish(ctx context.Context, event Event) error {
+	d.Notify(event)
+	return nil
+}
+
+// Wait blocks until all deliveries started by Notify have finished.
+func (d *WebhookDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Deliver POSTs an event to url, retrying with exponential backoff. When all
+// attempts fail, or the endpoint rejects the event outright, the event is
+// written to the dead-letter file and the last error is returned.
+func (d *WebhookDispatcher) Deliver(ctx context.Context, url string, deliveryID string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook event: %v", err)
+	}
+
+	backoff := d.config.InitialBackoff
+	attempts := 0
+	for {
+		attempts++
+		retry, err := d.post(ctx, url, deliveryID, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempts >= d.config.MaxAttempts {
+			d.writeDeadLetter(url, deliveryID, attempts, err, event)
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			d.writeDeadLetter(url, deliveryID, attempts, ctx.Err(), event)
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+}
+
+// post makes a single delivery attempt. It reports whether a failed attempt
+// is worth retrying: network errors, 5xx, 408 and 429 are, other 4xx are not.
+func (d *WebhookDispatcher) post(ctx context.Context, url string, deliveryID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(d.config.Secret, body))
+
+	resp, err := d.config.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error delivering webhook: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+}
+
+func (d *WebhookDispatcher) wants(eventType EventType) bool {
+	if len(d.config.Events) == 0 {
+		return true
+	}
+	for _, t := range d.config.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDeadLetter appends an undeliverable event to the dead-letter file.
+func (d *WebhookDispatcher) writeDeadLetter(url string, deliveryID string, attempts int, deliveryErr error, event Event) {
+	if d.config.DeadLetterPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetterEntry{
+		URL:        url,
+		DeliveryID: deliveryID,
+		Attempts:   attempts,
+		Error:      deliveryErr.Error(),
+		Time:       time.Now(),
+		Event:      event,
+	})
+	if err != nil {
+		return
+	}
+
+	d.deadLetter.Lock()
+	defer d.deadLetter.Unlock()
+
+	f, err := os.OpenFile(d.config.DeadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// SignWebhookPayload returns the signature header value for body.
+func SignWebhookPayload(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature is a valid signature
+// header value for body. Webhook receivers use it to authenticate deliveries.
+func VerifyWebhookSignature(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, body)), []byte(signature))
+}
@@ -0,0 +1,164 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcher_SignedDelivery(t *testing.T) {
+	secret := []byte("webhook-secret")
+	received := make(chan Event, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !VerifyWebhookSignature(secret, body, r.Header.Get(WebhookSignatureHeader)) {
+			t.Errorf("Invalid signature %q", r.Header.Get(WebhookSignatureHeader))
+		}
+		if r.Header.Get(WebhookDeliveryHeader) == "" {
+			t.Error("Expected delivery ID header")
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Invalid JSON payload: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	dispatcher := NewWebhookDispatcher(WebhookConfig{URLs: []string{server.URL}, Secret: secret})
+	u := NewUploader(Config{
+		TempDir:   filepath.Join(dir, "chunks"),
+		UploadDir: filepath.Join(dir, "uploads"),
+		OnEvent:   dispatcher.Notify,
+	})
+
+	req, _ := createUploadForm(map[string]string{
+		"uploadId":    "webhook-upload",
+		"fileName":    "hook.txt",
+		"chunkIndex":  "0",
+		"totalChunks": "1",
+		"fileSize":    "5",
+	}, []byte("Hello"))
+	if _, err := u.Handle(req); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	dispatcher.Wait()
+
+	select {
+	case event := <-received:
+		if event.Type != EventCompleted || event.UploadID != "webhook-upload" {
+			t.Errorf("Expected completed event for webhook-upload, got %+v", event)
+		}
+		if event.Metadata["storedName"] == nil {
+			t.Error("Expected metadata in event payload")
+		}
+	default:
+		t.Fatal("Expected webhook delivery")
+	}
+}
+
+func TestWebhookDispatcher_RetriesWithBackoff(t *testing.T) {
+	var attempts int32
+	var mu sync.Mutex
+	var times []time.Time
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(WebhookConfig{
+		URLs:           []string{server.URL},
+		InitialBackoff: 10 * time.Millisecond,
+	})
+
+	err := dispatcher.Deliver(context.Background(), server.URL, "delivery-1", Event{Type: EventFailed, FileName: "a.txt"})
+	if err != nil {
+		t.Fatalf("Expected delivery to succeed after retries, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if gap1, gap2 := times[1].Sub(times[0]), times[2].Sub(times[1]); gap2 < gap1 || gap1 < 10*time.Millisecond {
+		t.Errorf("Expected growing backoff, got %v then %v", gap1, gap2)
+	}
+}
+
+func TestWebhookDispatcher_DeadLetter(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	dispatcher := NewWebhookDispatcher(WebhookConfig{
+		URLs:           []string{server.URL},
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		DeadLetterPath: deadLetterPath,
+	})
+
+	dispatcher.Notify(Event{Type: EventAborted, UploadID: "dead-upload", FileName: "a.txt"})
+	dispatcher.Wait()
+
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	f, err := os.Open(deadLetterPath)
+	if err != nil {
+		t.Fatalf("Expected dead-letter file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var entries []deadLetterEntry
+	for scanner.Scan() {
+		var entry deadLetterEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid dead-letter line: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 1 || entries[0].Event.UploadID != "dead-upload" || entries[0].Attempts != 3 {
+		t.Errorf("Expected one dead-letter entry after 3 attempts, got %+v", entries)
+	}
+}
+
+func TestWebhookDispatcher_ClientErrorNotRetried(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(WebhookConfig{InitialBackoff: time.Millisecond})
+	if err := dispatcher.Deliver(context.Background(), server.URL, "delivery-1", Event{Type: EventFailed}); err == nil {
+		t.Error("Expected delivery error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt for 400, got %d", attempts)
+	}
+}
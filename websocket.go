@@ -0,0 +1,247 @@
+package chunkeduploader
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A minimal server side of RFC 6455, enough to push progress messages to
+// browsers without pulling in a WebSocket dependency.
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsCloseNormal = 1000
+
+	// wsMaxControlPayload bounds frames read from clients, which only ever
+	// need to send control frames to this server.
+	wsMaxControlPayload = 125
+
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// wsConn is an upgraded WebSocket connection.
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	write  sync.Mutex
+	closed chan struct{}
+}
+
+// upgradeWebSocket performs the opening handshake and starts reading client
+// frames in the background so pings are answered and disconnects noticed.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("error hijacking connection: %v", err)
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error completing websocket handshake: %v", err)
+	}
+
+	ws := &wsConn{conn: conn, rw: rw, closed: make(chan struct{})}
+	go ws.readLoop()
+	return ws, nil
+}
+
+// allowedOrigin reports whether a browser on the request's Origin may open a
+// WebSocket: the server's own origin and Config.AllowedOrigins are. Requests
+// without an Origin header do not come from a browser and are allowed.
+func (u *Uploader) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range u.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readLoop answers pings and closes c.closed once the client goes away.
+func (c *wsConn) readLoop() {
+	defer close(c.closed)
+	for {
+		opcode, payload, err := readWebSocketFrame(c.rw.Reader, wsMaxControlPayload)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			c.WriteMessage(wsOpPong, payload)
+		case wsOpClose:
+			return
+		}
+	}
+}
+
+// Closed returns a channel that is closed when the client disconnects.
+func (c *wsConn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// WriteMessage sends a single unfragmented frame.
+func (c *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	c.write.Lock()
+	defer c.write.Unlock()
+
+	if err := writeWebSocketFrame(c.rw.Writer, opcode, payload, nil); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// WriteClose sends a close frame with the given status code.
+func (c *wsConn) WriteClose(code uint16) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return c.WriteMessage(wsOpClose, payload)
+}
+
+// Close closes the underlying connection.
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// writeWebSocketFrame writes a final frame. Clients must mask their frames
+// and servers must not, so mask is nil on the server side.
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte, mask []byte) error {
+	header := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if mask != nil {
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readWebSocketFrame reads one frame, unmasking it if needed. Frames larger
+// than maxPayload are rejected.
+func readWebSocketFrame(r io.Reader, maxPayload uint64) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxPayload {
+		return 0, nil, fmt.Errorf("websocket frame too large: %d bytes", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}